@@ -0,0 +1,36 @@
+package asten
+
+import "runtime/metrics"
+
+const (
+	// allocMetric is the runtime metric counting the bytes allocated on the
+	// heap since the start of the program
+	allocMetric = "/gc/heap/allocs:bytes"
+	// gcCyclesMetric is the runtime metric counting the completed GC cycles
+	// since the start of the program
+	gcCyclesMetric = "/gc/cycles/total:gc-cycles"
+)
+
+// readAllocBytes returns the cumulative number of bytes allocated on the heap
+// by the whole program. Unlike [runtime.ReadMemStats] it does not stop the
+// world.
+func readAllocBytes() uint64 {
+	return readUint64Metric(allocMetric)
+}
+
+// readGCCycles returns the number of GC cycles completed by the whole program.
+func readGCCycles() uint64 {
+	return readUint64Metric(gcCyclesMetric)
+}
+
+// readUint64Metric returns the value of the runtime metric name, 0 if it is
+// not supported.
+func readUint64Metric(name string) uint64 {
+	s := []metrics.Sample{{Name: name}}
+	metrics.Read(s)
+
+	if s[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s[0].Value.Uint64()
+}
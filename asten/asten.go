@@ -19,32 +19,22 @@
 package asten
 
 import (
-	"os"
-	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 	"golang.org/x/exp/slog"
 )
 
-var default_condition_name = "base"
-
-func init() {
-	cores = uint64(runtime.NumCPU())
-
-	logLevel = new(slog.LevelVar)
-	h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})
-	logger = slog.New(h)
-}
-
-var (
-	cores    uint64
-	logger   *slog.Logger
-	logLevel *slog.LevelVar
-)
-
 // SetLogger set the logger used by asten.
 // [SetLogLevel] will not be enforced if a custom logger is used.
 func SetLogger(newlogger *slog.Logger) {
-	logger = newlogger
+	updateConfig(func(c *Config) {
+		c.logger = newlogger
+	})
 }
 
 // SetLogLevel sets the level for asten messages unless [SetLogger] has been called.
@@ -56,16 +46,249 @@ func SetLogLevel(level slog.Level) {
 // SetDefaultConditionName sets the name given to groups and profiles when a name is not specified.
 // The default value is "base".
 func SetDefaultConditionName(name string) {
-	default_condition_name = name
+	updateConfig(func(c *Config) {
+		c.defaultConditionName = name
+	})
+}
+
+// SetDefaultStringVerbose sets whether [ProfileSt.String] returns a full dump of
+// the profile (the default) or a single-line summary (see [ProfileSt.Summary]).
+func SetDefaultStringVerbose(verbose bool) {
+	updateConfig(func(c *Config) {
+		c.verboseString = verbose
+	})
+}
+
+// SetMaxProfileDepth sets the maximum depth of the sub-profiles created when
+// stopping timers (see [Timer.StopAs]), where profiles belonging to a group have
+// depth 1. Samples whose conditions would require creating deeper sub-profiles
+// are recorded at the deepest allowed level instead. Composite profiles at the
+// deepest level record them in their default condition, if it exists, or
+// themselves, made non-composite, if they have no sub-profile; otherwise the
+// samples are discarded.
+// The default value 0 means unlimited.
+func SetMaxProfileDepth(n int) {
+	if n >= 0 {
+		updateConfig(func(c *Config) {
+			c.maxProfileDepth = n
+		})
+	} else {
+		getLogger().Error("invalid max profile depth",
+			slog.Int("n", n))
+	}
+}
+
+// SetLabelSanitizer sets the function applied to group and profile names
+// before they are written to external output formats, i.e., by the JSON,
+// JSONL and pprof exporters and to benchmark metrics. The Print functions are
+// not affected.
+// If fn is nil the default sanitizer is restored, which replaces white
+// spaces, control characters and the characters ;,"'`\/|{}=# with underscores.
+func SetLabelSanitizer(fn func(string) string) {
+	if fn == nil {
+		fn = defaultLabelSanitizer
+	}
+	updateConfig(func(c *Config) {
+		c.labelSanitizer = fn
+	})
+}
+
+// sanitizeLabel returns name in a form that is safe to be written to external
+// output formats (see [SetLabelSanitizer]).
+func sanitizeLabel(name string) string {
+	return conf().labelSanitizer(name)
+}
+
+func defaultLabelSanitizer(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune(";,\"'`\\/|{}=#", r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// SetRatioPrecision sets the number of decimal digits used when displaying
+// ratios, such as timeslice and branch taken, in every output format.
+// Ratios are truncated, not rounded. The default value is 3.
+func SetRatioPrecision(digits int) {
+	if digits >= 0 {
+		updateConfig(func(c *Config) {
+			c.ratioPrecision = digits
+		})
+	} else {
+		getLogger().Error("invalid ratio precision",
+			slog.Int("digits", digits))
+	}
+}
+
+// SetIdleThreshold makes the Print functions flag as idle the profiles which
+// have not recorded any sample for longer than d (see [ProfileSt.Staleness]).
+// A non-positive d, the default, disables the flag.
+func SetIdleThreshold(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	updateConfig(func(c *Config) {
+		c.idleThreshold = d
+	})
+}
+
+// SetCollapseDefaultCondition sets whether the sub-profile of the default
+// condition of composite profiles (see [SetDefaultConditionName]), which
+// collects the samples recorded using [Timer.Stop], is hidden by the Print
+// functions and omitted from snapshots and their JSON exports. Its
+// statistics remain included in the ones of the composite profile, so that
+// they are displayed merged in the row of the latter rather than as a
+// separate child. Composite default conditions are never hidden.
+// The default value is false.
+func SetCollapseDefaultCondition(collapse bool) {
+	updateConfig(func(c *Config) {
+		c.collapseDefault = collapse
+	})
+}
+
+// SetMaxNameWidth sets the maximum number of characters of the full names of
+// the profiles displayed by the Print functions. Longer names are shortened
+// with an ellipsis in the middle, keeping the name of the profile itself
+// visible, e.g., "a -> b -> c -> d" may become "a -…> c -> d".
+// The default value 0 means unlimited.
+func SetMaxNameWidth(n int) {
+	if n < 0 {
+		getLogger().Error("invalid max name width",
+			slog.Int("n", n))
+		return
+	}
+
+	updateConfig(func(c *Config) {
+		c.maxNameWidth = n
+	})
+}
+
+// truncateName shortens fullName to at most width characters, replacing its
+// middle with an ellipsis while keeping as much as possible of leaf, the last
+// element of fullName. A width of 0 means unlimited.
+func truncateName(fullName, leaf string, width int) string {
+	rs := []rune(fullName)
+	if width == 0 || len(rs) <= width {
+		return fullName
+	}
+	if width == 1 {
+		return "\u2026"
+	}
+
+	// a third of the space to the head, unless needed by the leaf
+	tail := width - 1 - (width-1)/3
+	if l := len([]rune(leaf)); l > tail {
+		tail = l
+		if tail > width-1 {
+			tail = width - 1
+		}
+	}
+	head := width - 1 - tail
+
+	return string(rs[:head]) + "\u2026" + string(rs[len(rs)-tail:])
+}
+
+// roundRatio truncates x to the number of decimal digits set using
+// [SetRatioPrecision].
+// Digits are dropped from the shortest decimal representation of x, e.g., 0.29,
+// rather than computed from its binary value, slightly smaller, so that
+// truncating an already truncated ratio, e.g., decoded from an export, leaves
+// it unchanged.
+func roundRatio(x float64) float64 {
+	s := strconv.FormatFloat(x, 'f', -1, 64)
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		if prec := conf().ratioPrecision; len(s)-i-1 > prec {
+			s = strings.TrimSuffix(s[:i+1+prec], ".")
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return x
+	}
+	return v
+}
+
+// # Glyphs
+//
+// Contains the symbols preceding the titles of the tables generated by the
+// Print functions (see [SetGlyphs]).
+type Glyphs struct {
+	Profile string // e.g., "[P]" in "[P] Profile p"
+	Group   string
+	Groups  string // see PrintGroups
+}
+
+var (
+	// ASCIIGlyphs are rendered by any terminal, they are the default glyphs
+	ASCIIGlyphs = Glyphs{Profile: "[P]", Group: "[G]", Groups: "[*]"}
+	// UnicodeGlyphs require a font providing the circled letters and, for
+	// Groups, a Nerd Font
+	UnicodeGlyphs = Glyphs{Profile: "\u24c5", Group: "\u24bc", Groups: "\uf111"}
+)
+
+// SetGlyphs sets the symbols preceding the titles of the tables generated by
+// the Print functions. The default glyphs are [ASCIIGlyphs].
+func SetGlyphs(g Glyphs) {
+	updateConfig(func(c *Config) {
+		c.glyphs = g
+	})
+}
+
+// SetSuppressCompositeWarnings sets whether the warnings logged when a profile
+// is implicitly made composite, e.g., by [Timer.StopAs] with a condition, are
+// suppressed. It is meant for designs in which such transitions are expected,
+// other messages are still logged. The default value is false.
+func SetSuppressCompositeWarnings(suppress bool) {
+	updateConfig(func(c *Config) {
+		c.quietComposite = suppress
+	})
+}
+
+// warnComposite logs msg about profile p being implicitly made composite,
+// unless suppressed (see [SetSuppressCompositeWarnings]).
+func warnComposite(p *ProfileSt, msg string) {
+	if conf().quietComposite {
+		return
+	}
+	getLogger().Warn(msg,
+		slog.String("profile", p.getFullName()))
 }
 
 // SetCoresNumber sets the number of cores available when calculating statistics.
 // Default value is initialized using [runtime.NumCPU].
 func SetCoresNumber(n uint64) {
 	if n > 0 {
-		cores = n
+		updateConfig(func(c *Config) {
+			c.cores = n
+		})
 	} else {
-		logger.Error("invalid cores number",
+		getLogger().Error("invalid cores number",
 			slog.Uint64("n", n))
 	}
 }
+
+// SetAllowOvercommit sets whether [ProfileBuilder.WithNThreads] accepts a
+// number of threads greater than the number of cores (see [SetCoresNumber]),
+// e.g., to model a machine larger than the current one. The default value
+// false makes the number of threads be clamped to the number of cores.
+func SetAllowOvercommit(allow bool) {
+	updateConfig(func(c *Config) {
+		c.overcommit = allow
+	})
+}
+
+// sortedKeys returns the keys of m in increasing order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := maps.Keys(m)
+	slices.Sort(keys)
+	return keys
+}
+
+// indent prefixes each line of s with a tab. The returned string is always
+// terminated by a newline.
+func indent(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return "\t" + strings.Replace(s, "\n", "\n\t", -1) + "\n"
+}
@@ -0,0 +1,52 @@
+package asten
+
+import (
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// record registers in profile p a sample lasting d, as if measured by a timer
+// stopped calling StopAs(conds...), or Stop if conds is empty.
+func record(p *ProfileSt, d time.Duration, conds ...string) {
+	start := time.Unix(0, 0)
+	p.RecordBatch([]Span{{Start: start, End: start.Add(d), Conds: conds}})
+}
+
+// restoreConfig restores the package-wide settings at the end of t.
+func restoreConfig(t *testing.T) {
+	c := SaveConfig()
+	t.Cleanup(func() { RestoreConfig(c) })
+}
+
+func TestTruncateName(t *testing.T) {
+	tests := []struct {
+		fullName, leaf string
+		width          int
+		want           string
+	}{
+		{"db -> query", "query", 0, "db -> query"},
+		{"db -> query", "query", 11, "db -> query"},
+		{"db -> query", "query", 1, "\u2026"},
+		{"group -> parent -> leaf", "leaf", 12, "gro\u2026 -> leaf"},
+		// narrower than the leaf, whose end is kept
+		{"db -> verylongleaf", "verylongleaf", 5, "\u2026leaf"},
+		{"db -> verylongleaf", "verylongleaf", 2, "\u2026f"},
+		// widths falling inside multi-byte runes when counted in bytes
+		{"数据库 -> 查询语句", "查询语句", 6, "数\u2026查询语句"},
+		{"数据库 -> 查询语句", "查询语句", 3, "\u2026语句"},
+		{"数据库 -> 查询语句", "查询语句", 10, "数据库\u2026> 查询语句"},
+	}
+	for _, tt := range tests {
+		got := truncateName(tt.fullName, tt.leaf, tt.width)
+		if got != tt.want {
+			t.Errorf("truncateName(%q, %q, %d) = %q, want %q", tt.fullName, tt.leaf, tt.width, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncateName(%q, %q, %d) = %q, invalid UTF-8", tt.fullName, tt.leaf, tt.width, got)
+		}
+		if n := utf8.RuneCountInString(got); tt.width > 0 && n > tt.width {
+			t.Errorf("truncateName(%q, %q, %d) has %d characters", tt.fullName, tt.leaf, tt.width, n)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package asten
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/exp/slog"
+)
+
+// retainedSamples keeps track of the samples retained by the statistics of
+// memory full profiles, see SetGlobalSampleBudget. Statistics are registered
+// once they retain samples while a budget is set.
+var retainedSamples = struct {
+	sync.Mutex // serializes the registrations and the evictions
+	total      atomic.Uint64
+	entries    map[*retention]struct{}
+}{entries: make(map[*retention]struct{})}
+
+// retention is the entry of the statistics of a profile among the retained
+// samples. It does not reference the statistics, so that profiles no longer
+// referenced can be garbage collected: the entry is removed by the finalizer
+// of the retentionHandle owned by the statistics.
+type retention struct {
+	count   atomic.Uint64 // retained samples, excluding the pending ones
+	pending atomic.Uint64 // samples to be evicted, see applyEvictions
+}
+
+// retentionHandle is owned by the statistics registered among the retained
+// samples. Unlike the statistics, it is not part of a reference cycle, hence
+// its finalizer runs once they are unreachable.
+type retentionHandle struct {
+	entry *retention
+}
+
+// minEvictionFraction is the minimum fraction of the samples of a profile
+// evicted at once, so that the cost of the eviction is amortized
+const minEvictionFraction = 8
+
+// SetGlobalSampleBudget sets the maximum number of samples retained by all the
+// memory full profiles combined. Once the budget is exceeded the oldest
+// samples of the profiles retaining the most samples are evicted, at least an
+// eighth of the samples of a profile at once. The statistics of the evicted
+// samples are preserved, as by [ProfileSt.CompactSamples], while percentiles
+// are computed over the retained samples only.
+// Samples are evicted from the profile exceeding the budget immediately, and
+// from the others the next time they record a sample or compute their
+// statistics. Samples retained by a profile before a budget is set are
+// accounted once it records a new sample.
+// The default value 0 means unlimited: samples are not accounted.
+func SetGlobalSampleBudget(maxSamples uint64) {
+	updateConfig(func(c *Config) {
+		c.sampleBudget = maxSamples
+	})
+}
+
+// retain accounts for a sample appended to the statistics s, which must be
+// locked, evicting samples if the budget is exceeded.
+func (s *profileStats) retain() {
+	budget := conf().sampleBudget
+	if s.budget == nil {
+		if budget == 0 {
+			return
+		}
+		s.register()
+	} else {
+		s.applyEvictions()
+		s.budget.entry.count.Add(1)
+		retainedSamples.total.Add(1)
+	}
+
+	if total := retainedSamples.total.Load(); budget > 0 && total > budget {
+		retainedSamples.Lock()
+		evictSamples(total - budget)
+		retainedSamples.Unlock()
+		s.applyEvictions()
+	}
+}
+
+// register adds the statistics s, which must be locked, to the retained
+// samples, accounting for all its samples.
+func (s *profileStats) register() {
+	entry := &retention{}
+	entry.count.Store(uint64(len(s.samples)))
+
+	retainedSamples.Lock()
+	retainedSamples.entries[entry] = struct{}{}
+	retainedSamples.total.Add(uint64(len(s.samples)))
+	retainedSamples.Unlock()
+
+	s.budget = &retentionHandle{entry: entry}
+	runtime.SetFinalizer(s.budget, func(h *retentionHandle) {
+		h.entry.unregister()
+	})
+}
+
+// unregister removes e from the retained samples, if still registered.
+func (e *retention) unregister() {
+	retainedSamples.Lock()
+	defer retainedSamples.Unlock()
+
+	if _, ok := retainedSamples.entries[e]; !ok {
+		return
+	}
+	retainedSamples.total.Add(-e.count.Load())
+	delete(retainedSamples.entries, e)
+}
+
+// release accounts for the samples of the statistics s, which must be locked,
+// being discarded. It must be called before discarding them.
+func (s *profileStats) release() {
+	if s.budget == nil {
+		return
+	}
+	runtime.SetFinalizer(s.budget, nil)
+	s.budget.entry.unregister()
+	s.budget = nil
+}
+
+// evictSamples marks for eviction at least n samples, starting from the
+// statistics retaining the most samples (see applyEvictions).
+// retainedSamples must be locked.
+func evictSamples(n uint64) {
+	for n > 0 {
+		var victim *retention
+		for e := range retainedSamples.entries {
+			if victim == nil || e.count.Load() > victim.count.Load() {
+				victim = e
+			}
+		}
+		if victim == nil || victim.count.Load() == 0 {
+			getLogger().Warn("sample budget exceeded, no profile could be evicted",
+				slog.Uint64("retained", retainedSamples.total.Load()))
+			return
+		}
+
+		count := victim.count.Load()
+		k := n
+		if least := count / minEvictionFraction; k < least {
+			k = least
+		}
+		if k > count {
+			k = count
+		}
+
+		victim.count.Add(-k)
+		victim.pending.Add(k)
+		retainedSamples.total.Add(-k)
+		if k >= n {
+			return
+		}
+		n -= k
+	}
+}
+
+// applyEvictions evicts the samples of the statistics s, which must be locked,
+// marked for eviction by evictSamples.
+func (s *profileStats) applyEvictions() {
+	if s.budget == nil {
+		return
+	}
+	if n := s.budget.entry.pending.Swap(0); n > 0 {
+		s.evictOldest(n)
+	}
+}
+
+// evictOldest discards the n oldest samples retained by the statistics s,
+// preserving their statistics.
+func (s *profileStats) evictOldest(n uint64) {
+	if retained := uint64(len(s.samples)); n > retained {
+		n = retained
+	}
+
+	for _, sample := range s.samples[:n] {
+		d := sample.getDurationNano()
+		s.addTime(&s.carriedTotal, wideOf(d))
+		s.addTime(&s.carriedEffective, wideOf(d/s.threadDivisor()))
+	}
+	s.carriedN += n
+
+	// copied to release the memory of the evicted samples
+	s.samples = append([]sample(nil), s.samples[n:]...)
+	s.invalidate()
+}
@@ -0,0 +1,70 @@
+package asten
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// registeredRetentions returns the number of statistics accounted among the
+// retained samples.
+func registeredRetentions() int {
+	retainedSamples.Lock()
+	defer retainedSamples.Unlock()
+	return len(retainedSamples.entries)
+}
+
+func TestSampleBudgetUnlimitedNotAccounted(t *testing.T) {
+	restoreConfig(t)
+	SetGlobalSampleBudget(0)
+
+	before := registeredRetentions()
+	p := NewProfile("unlimited", WithMemory())
+	for i := 0; i < 10; i++ {
+		record(p, time.Millisecond)
+	}
+	if p.stats.budget != nil || registeredRetentions() != before {
+		t.Error("samples accounted without a budget")
+	}
+}
+
+func TestSampleBudgetEvicts(t *testing.T) {
+	restoreConfig(t)
+	SetGlobalSampleBudget(100)
+
+	p := NewProfile("evicted", WithMemory())
+	for i := 0; i < 1000; i++ {
+		record(p, time.Millisecond)
+	}
+	defer p.CompactSamples()
+
+	if n := len(p.Samples()); n > 100 {
+		t.Errorf("%d samples retained, want at most 100", n)
+	}
+	if n := p.Aggregate().NSamples; n != 1000 {
+		t.Errorf("%d samples counted, want 1000", n)
+	}
+}
+
+func TestSampleBudgetReleasesUnreachable(t *testing.T) {
+	restoreConfig(t)
+	SetGlobalSampleBudget(1 << 20)
+
+	before := registeredRetentions()
+	func() {
+		g := NewUnregisteredGroup("unreachable", WithMemory())
+		record(g.Profile("p"), time.Millisecond)
+	}()
+	if registeredRetentions() != before+1 {
+		t.Fatal("samples not accounted")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for registeredRetentions() != before {
+		if time.Now().After(deadline) {
+			t.Fatal("statistics of an unreachable profile still accounted")
+		}
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,208 @@
+package asten
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rodaine/table"
+	"golang.org/x/exp/slog"
+)
+
+// # Column
+//
+// Represents a metric that can be displayed as a column of the tables
+// generated by the Print functions (see [SetColumns]).
+type Column int
+
+const (
+	ColumnProfile Column = iota
+	ColumnTimeslice
+	ColumnTotalRuntime
+	ColumnEffectiveRuntime
+	ColumnMeanRuntime
+	ColumnBranchTaken
+	ColumnNSamples
+	ColumnP50
+	ColumnP90
+	ColumnP99
+	ColumnErrorRate
+	ColumnMeanAlloc
+	ColumnP999
+	ColumnMeanStdErr
+	ColumnMeanCPUTime
+
+	numColumns // number of available columns, must be last
+)
+
+// notAvailable is displayed in place of metrics that cannot be computed for
+// a given profile, e.g., percentiles of memoryless profiles.
+const notAvailable = "N/A"
+
+// notEnoughSamples is displayed in place of percentiles that cannot be
+// estimated reliably (see [ProfileSt.PercentileWithConfidence]).
+const notEnoughSamples = "\u2014"
+
+// SetColumns sets the columns displayed by the Print functions, in the given
+// order.
+// The default columns are: profile, timeslice, total runtime, effective runtime,
+// mean runtime, branch taken and nsamples.
+// Metrics that cannot be computed for a profile are displayed as N/A, while
+// percentiles of profiles having too few samples to estimate them reliably are
+// displayed as —.
+func SetColumns(cols []Column) {
+	if !validColumns(cols) {
+		return
+	}
+
+	cols = append([]Column(nil), cols...)
+	updateConfig(func(c *Config) {
+		c.columns = cols
+	})
+}
+
+// validColumns returns whether cols is a valid, non-empty, selection of
+// columns, logging an error otherwise.
+func validColumns(cols []Column) bool {
+	if len(cols) == 0 {
+		getLogger().Error("at least one column must be specified")
+		return false
+	}
+
+	for _, c := range cols {
+		if c < 0 || c >= numColumns {
+			getLogger().Error("invalid column",
+				slog.Int("column", int(c)))
+			return false
+		}
+	}
+	return true
+}
+
+func (c Column) String() string {
+	switch c {
+	case ColumnProfile:
+		return "profile"
+	case ColumnTimeslice:
+		return "timeslice"
+	case ColumnTotalRuntime:
+		return "total runtime"
+	case ColumnEffectiveRuntime:
+		return "effective runtime"
+	case ColumnMeanRuntime:
+		return "mean runtime"
+	case ColumnBranchTaken:
+		return "branch taken"
+	case ColumnNSamples:
+		return "nsamples"
+	case ColumnP50:
+		return "p50"
+	case ColumnP90:
+		return "p90"
+	case ColumnP99:
+		return "p99"
+	case ColumnErrorRate:
+		return "err%"
+	case ColumnMeanAlloc:
+		return "alloc"
+	case ColumnP999:
+		return "p99.9"
+	case ColumnMeanStdErr:
+		return "mean \u00b1 stderr"
+	case ColumnMeanCPUTime:
+		return "mean cpu time"
+	}
+	return "unknown"
+}
+
+// value returns the content of column c for the (already updated) profile p.
+func (c Column) value(p *ProfileSt) interface{} {
+	if p.stats.replaced() && c != ColumnProfile && c != ColumnBranchTaken && c != ColumnNSamples {
+		// displayed by the engine table
+		return notAvailable
+	}
+
+	switch c {
+	case ColumnProfile:
+		return p.displayName()
+	case ColumnTimeslice:
+		return roundRatio(p.stats.timeslice)
+	case ColumnTotalRuntime:
+		return p.stats.totalTime.duration()
+	case ColumnEffectiveRuntime:
+		return p.stats.effectiveTime.duration()
+	case ColumnMeanRuntime:
+		return time.Duration(p.stats.meanTime)
+	case ColumnBranchTaken:
+		return roundRatio(p.stats.taken)
+	case ColumnNSamples:
+		return p.stats.count()
+	case ColumnP50:
+		return percentileValue(p, 0.5)
+	case ColumnP90:
+		return percentileValue(p, 0.9)
+	case ColumnP99:
+		return percentileValue(p, 0.99)
+	case ColumnErrorRate:
+		return fmt.Sprintf("%.2f%%", p.stats.errorRate()*100)
+	case ColumnMeanAlloc:
+		return fmt.Sprintf("%dB", p.stats.meanAlloc())
+	case ColumnP999:
+		return percentileValue(p, 0.999)
+	case ColumnMeanStdErr:
+		return fmt.Sprintf("%v \u00b1 %v", time.Duration(p.stats.meanTime), time.Duration(p.stats.stdErr()))
+	case ColumnMeanCPUTime:
+		return time.Duration(p.stats.meanCPU())
+	}
+	return notAvailable
+}
+
+func percentileValue(p *ProfileSt, q float64) interface{} {
+	d, ok := p.percentile(q)
+	if !ok {
+		return notAvailable
+	}
+	if !p.enoughSamples(q) {
+		return notEnoughSamples
+	}
+	return d
+}
+
+// leafColumns returns the columns displayed for non-composite profiles, i.e.,
+// all the given columns except the timeslice.
+func leafColumns(columns []Column) []Column {
+	cols := make([]Column, 0, len(columns))
+	for _, c := range columns {
+		if c != ColumnTimeslice {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// newTable returns a table whose headers are the given prefix followed by
+// the names of cols.
+func newTable(cols []Column, prefix ...string) table.Table {
+	return table.New(headers(cols, prefix...)...).WithWidthFunc(visibleWidth)
+}
+
+// headers returns the given prefix followed by the names of cols.
+func headers(cols []Column, prefix ...string) []interface{} {
+	hs := make([]interface{}, 0, len(prefix)+len(cols))
+	for _, h := range prefix {
+		hs = append(hs, h)
+	}
+	for _, c := range cols {
+		hs = append(hs, c.String())
+	}
+	return hs
+}
+
+// row returns the cells of the row describing p, preceded by prefix.
+func row(p *ProfileSt, cols []Column, prefix ...interface{}) []interface{} {
+	cells := make([]interface{}, 0, len(prefix)+len(cols))
+	cells = append(cells, prefix...)
+	for _, c := range cols {
+		cells = append(cells, c.value(p))
+	}
+	return cells
+}
@@ -0,0 +1,177 @@
+package asten
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// # ComparisonResult
+//
+// Contains the outcome of the comparison of the runtimes of two profiles (see
+// [CompareProfiles]).
+type ComparisonResult struct {
+	MeanA time.Duration
+	MeanB time.Duration
+	// T is the Welch's t-statistic, negative if a is faster than b
+	T float64
+	// DF is the Welch–Satterthwaite approximation of the degrees of freedom
+	DF float64
+	// PValue is the two-tailed probability of observing a difference at least
+	// as large as the measured one if the two profiles had the same mean runtime
+	PValue float64
+	// Faster is the profile with the lowest mean runtime, nil if the means are equal
+	Faster *ProfileSt
+}
+
+// Significant returns whether the difference between the mean runtimes is
+// statistically significant at level alpha, e.g., 0.05.
+func (r ComparisonResult) Significant(alpha float64) bool {
+	return r.PValue < alpha
+}
+
+// CompareProfiles performs a Welch's t-test on the samples recorded by a and b
+// (or by their non-composite descendants if composite) to establish whether
+// their mean runtimes differ significantly.
+// Both profiles, and all their non-composite descendants, must be memory full
+// and contain at least two samples: otherwise an error is logged and a result
+// with a p-value of 1 is returned.
+func CompareProfiles(a, b *ProfileSt) ComparisonResult {
+	res := ComparisonResult{PValue: 1}
+
+	na, meanA, varA, ok := sampleMoments(a)
+	if !ok {
+		return res
+	}
+	nb, meanB, varB, ok := sampleMoments(b)
+	if !ok {
+		return res
+	}
+
+	res.MeanA = time.Duration(meanA)
+	res.MeanB = time.Duration(meanB)
+	switch {
+	case meanA < meanB:
+		res.Faster = a
+	case meanB < meanA:
+		res.Faster = b
+	}
+
+	sa, sb := varA/na, varB/nb
+	if sa+sb == 0 {
+		// no variability: any difference is significant
+		if meanA != meanB {
+			res.PValue = 0
+			res.T = math.Copysign(math.Inf(1), meanA-meanB)
+		}
+		return res
+	}
+
+	res.T = (meanA - meanB) / math.Sqrt(sa+sb)
+	res.DF = (sa + sb) * (sa + sb) / (sa*sa/(na-1) + sb*sb/(nb-1))
+	res.PValue = studentTwoTailed(res.T, res.DF)
+	return res
+}
+
+// sampleMoments returns the number of samples recorded by p, their mean and
+// their unbiased variance, in nanoseconds.
+func sampleMoments(p *ProfileSt) (n, mean, variance float64, ok bool) {
+	p.recursiveLock()
+	ds, ok := p.durations(nil)
+	p.recursiveUnlock()
+
+	if !ok {
+		getLogger().Error("cannot compare memoryless profiles",
+			slog.String("profile", p.getFullName()))
+		return 0, 0, 0, false
+	}
+	if len(ds) < 2 {
+		getLogger().Error("at least two samples are needed to compare profiles",
+			slog.String("profile", p.getFullName()))
+		return 0, 0, 0, false
+	}
+
+	for _, d := range ds {
+		mean += float64(d)
+	}
+	n = float64(len(ds))
+	mean /= n
+
+	for _, d := range ds {
+		variance += (float64(d) - mean) * (float64(d) - mean)
+	}
+	variance /= n - 1
+
+	return n, mean, variance, true
+}
+
+// studentTwoTailed returns P(|X| >= |t|) where X follows a Student's
+// t-distribution with df degrees of freedom.
+func studentTwoTailed(t, df float64) float64 {
+	return regIncBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// regIncBeta returns the regularized incomplete beta function I_x(a, b).
+func regIncBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+
+	// the continued fraction converges quickly only for x < (a+1)/(a+b+2)
+	if x < (a+1)/(a+b+2) {
+		return front * betaContinuedFraction(a, b, x) / a
+	}
+	return 1 - front*betaContinuedFraction(b, a, 1-x)/b
+}
+
+// betaContinuedFraction evaluates the continued fraction of the incomplete
+// beta function using the modified Lentz's method.
+func betaContinuedFraction(a, b, x float64) float64 {
+	const (
+		maxIterations = 200
+		epsilon       = 1e-14
+		tiny          = 1e-300
+	)
+
+	c := 1.0
+	d := 1 - (a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	f := d
+
+	for m := 1; m <= maxIterations; m++ {
+		fm := float64(m)
+
+		for _, num := range [2]float64{
+			fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm)),
+			-(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1)),
+		} {
+			d = 1 + num*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + num/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			f *= c * d
+		}
+
+		if math.Abs(c*d-1) < epsilon {
+			break
+		}
+	}
+	return f
+}
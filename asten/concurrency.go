@@ -0,0 +1,88 @@
+package asten
+
+import (
+	"time"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+)
+
+// ObservedConcurrency returns the mean number of samples of profile p (or of
+// its descendants if p is composite) running at the same time while any of
+// them was running, i.e., the total runtime divided by the wall-clock time
+// covered by the samples (see [ProfileSt.EffectiveTimeObserved]).
+// Unlike the number of threads of multi-threaded profiles, it reflects the
+// concurrency actually observed. It returns 0 if no sample has been recorded.
+// Only memory full profiles retain the samples needed: for any other profile an
+// error is logged and 0 is returned.
+func (p *ProfileSt) ObservedConcurrency() float64 {
+	total, covered, ok := p.observedTimes()
+	if !ok || covered == 0 {
+		return 0
+	}
+	return float64(total) / float64(covered)
+}
+
+// EffectiveTimeObserved returns the wall-clock time covered by the samples of
+// profile p (or of its descendants if p is composite), i.e., the length of the
+// union of their intervals. It is an alternative to the effective runtime of
+// multi-threaded profiles based on the measured overlap of the samples rather
+// than on a static number of threads.
+// Only memory full profiles retain the samples needed: for any other profile an
+// error is logged and 0 is returned.
+func (p *ProfileSt) EffectiveTimeObserved() time.Duration {
+	_, covered, _ := p.observedTimes()
+	return covered
+}
+
+// observedTimes returns the total runtime of the samples of p and the
+// wall-clock time they cover, false if the samples are not available.
+func (p *ProfileSt) observedTimes() (total, covered time.Duration, ok bool) {
+	p.recursiveLock()
+	ss, ok := p.allSamples(nil)
+	p.recursiveUnlock()
+
+	if !ok {
+		getLogger().Error("observed concurrency requires memory full profiles",
+			slog.String("profile", p.getFullName()))
+		return 0, 0, false
+	}
+
+	slices.SortFunc(ss, func(a, b sample) bool {
+		return a.start.Before(b.start)
+	})
+
+	// merge the overlapping intervals, sorted by start
+	var end time.Time
+	for i, s := range ss {
+		total += s.end.Sub(s.start)
+		switch {
+		case i == 0 || s.start.After(end):
+			covered += s.end.Sub(s.start)
+			end = s.end
+		case s.end.After(end):
+			covered += s.end.Sub(end)
+			end = s.end
+		}
+	}
+	return total, covered, true
+}
+
+// allSamples appends to ss the samples recorded by p and its descendants,
+// false if any of them is memoryless.
+func (p *ProfileSt) allSamples(ss []sample) ([]sample, bool) {
+	if !p.composite {
+		if !p.memory {
+			return ss, false
+		}
+		return append(ss, p.stats.samples...), true
+	}
+
+	for _, sp := range p.subProfiles {
+		var ok bool
+		if ss, ok = sp.allSamples(ss); !ok {
+			return ss, false
+		}
+	}
+	return ss, true
+}
@@ -0,0 +1,346 @@
+package asten
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	"golang.org/x/exp/slog"
+)
+
+// Run with the race detector, e.g., go test -race.
+func TestMakeCompositeConcurrentWithStopAs(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+
+	g := NewUnregisteredGroup("g", WithComposite())
+	p := g.Profile("p")
+
+	const n = 200
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			p.StartTimer().StopAs("hot", fmt.Sprint("leaf", i%4))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			p.Profile(fmt.Sprint("cold", i)).MakeComposite()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			p.StartTimer().StopAs(fmt.Sprint("cold", i), "leaf")
+		}
+	}()
+	wg.Wait()
+
+	if got := p.Profile("hot").SubProfileCount(); got != 4 {
+		t.Errorf("hot has %d sub-profiles, want 4", got)
+	}
+	var total uint64
+	for i := 0; i < n; i++ {
+		total += p.Profile(fmt.Sprint("cold", i)).Profile("leaf").Aggregate().NSamples
+	}
+	if total != n {
+		t.Errorf("cold profiles recorded %d samples, want %d", total, n)
+	}
+}
+
+// Run with the race detector, e.g., go test -race.
+func TestSetDefaultConditionNameConcurrentWithProfile(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+
+	names := []string{"race-default-a", "race-default-b"}
+	SetDefaultConditionName(names[0])
+	composite := NewUnregisteredGroup("g", WithComposite()).Profile("composite")
+
+	const n = 200
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			SetDefaultConditionName(names[i%2])
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			Profile("p").StartTimer().Stop()
+			composite.StartTimer().Stop()
+		}
+	}()
+	wg.Wait()
+
+	var inGroups, inComposite uint64
+	for _, name := range names {
+		inGroups += Group(name).Profile("p").Snapshot().NSamples
+		if sp, ok := composite.ConditionBreakdown()[name]; ok {
+			inComposite += sp.NSamples
+		}
+	}
+	if inGroups != n {
+		t.Errorf("default groups recorded %d samples, want %d", inGroups, n)
+	}
+	if inComposite != n {
+		t.Errorf("default conditions recorded %d samples, want %d", inComposite, n)
+	}
+}
+
+func TestObservedConcurrency(t *testing.T) {
+	restoreConfig(t)
+	SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// intervals in milliseconds
+	tests := []struct {
+		name        string
+		intervals   [][2]int
+		covered     time.Duration
+		concurrency float64
+	}{
+		{"none", nil, 0, 0},
+		{"single", [][2]int{{0, 10}}, 10 * time.Millisecond, 1},
+		{"disjoint", [][2]int{{20, 30}, {0, 10}}, 20 * time.Millisecond, 1},
+		{"overlapping", [][2]int{{0, 10}, {5, 15}}, 15 * time.Millisecond, 20.0 / 15},
+		{"nested", [][2]int{{0, 20}, {5, 10}}, 20 * time.Millisecond, 25.0 / 20},
+		{"touching", [][2]int{{0, 10}, {10, 20}}, 20 * time.Millisecond, 1},
+		{"identical", [][2]int{{0, 10}, {0, 10}}, 10 * time.Millisecond, 2},
+		{"chained", [][2]int{{10, 20}, {0, 15}, {18, 30}, {40, 45}}, 35 * time.Millisecond, 42.0 / 35},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProfile("p", WithMemory())
+			start := time.Unix(0, 0)
+			var spans []Span
+			for _, iv := range tt.intervals {
+				spans = append(spans, Span{
+					Start: start.Add(time.Duration(iv[0]) * time.Millisecond),
+					End:   start.Add(time.Duration(iv[1]) * time.Millisecond),
+				})
+			}
+			p.RecordBatch(spans)
+
+			if got := p.EffectiveTimeObserved(); got != tt.covered {
+				t.Errorf("covered %v, want %v", got, tt.covered)
+			}
+			if got := p.ObservedConcurrency(); math.Abs(got-tt.concurrency) > 1e-9 {
+				t.Errorf("concurrency %v, want %v", got, tt.concurrency)
+			}
+		})
+	}
+
+	memoryless := NewProfile("memoryless")
+	record(memoryless, time.Millisecond)
+	if got := memoryless.ObservedConcurrency(); got != 0 {
+		t.Errorf("memoryless concurrency %v, want 0", got)
+	}
+}
+
+// Run with the race detector, e.g., go test -race.
+func TestFlushConcurrentWithDeepStopAs(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+
+	p := NewProfile("p", WithComposite())
+
+	const recorders, n = 4, 5000
+	var wg sync.WaitGroup
+	wg.Add(recorders + 1)
+	for r := 0; r < recorders; r++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				p.StartTimer().StopAs("a", "b", "c")
+			}
+		}()
+	}
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			p.Flush()
+			p.Aggregate()
+		}
+	}()
+	wg.Wait()
+
+	if got := p.Aggregate().NSamples; got != recorders*n {
+		t.Errorf("NSamples = %d, want %d", got, recorders*n)
+	}
+}
+
+// Run with the race detector, e.g., go test -race.
+func TestCompactSamplesConcurrentWithDeepStopAs(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+
+	p := NewProfile("p", WithComposite(), WithMemory())
+
+	const n = 5000
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			p.StartTimer().StopAs("a", "b", fmt.Sprint("c", i%2))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			p.CompactSamples()
+		}
+	}()
+	wg.Wait()
+
+	if got := p.Aggregate().NSamples; got != n {
+		t.Errorf("NSamples = %d, want %d", got, n)
+	}
+}
+
+// Run with the race detector, e.g., go test -race.
+func TestResetConditionConcurrentWithDeepStopAs(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+
+	p := NewProfile("p", WithComposite())
+	p.StartTimer().StopAs("a", "b", "c")
+
+	const n = 5000
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			p.StartTimer().StopAs("a", "b", "c")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			p.ResetCondition("a")
+		}
+	}()
+	wg.Wait()
+
+	p.ResetCondition("a")
+	if got := p.Aggregate().NSamples; got != 0 {
+		t.Errorf("NSamples = %d after reset, want 0", got)
+	}
+}
+
+// Run with the race detector, e.g., go test -race.
+func TestPrintDeltaConcurrentWithDeepStopAs(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+	output, tableWriter := color.Output, table.DefaultWriter
+	color.Output, table.DefaultWriter = io.Discard, io.Discard
+	t.Cleanup(func() { color.Output, table.DefaultWriter = output, tableWriter })
+
+	g := NewUnregisteredGroup("g", WithComposite())
+	p := g.Profile("p")
+
+	const recorders, n = 4, 5000
+	var wg sync.WaitGroup
+	wg.Add(recorders + 1)
+	for r := 0; r < recorders; r++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				p.StartTimer().StopAs("a", "b", "c")
+			}
+		}()
+	}
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n/5; i++ {
+			p.PrintDelta()
+			g.PrintDelta()
+		}
+	}()
+	wg.Wait()
+
+	// the baselines of the leaves are consistent with their statistics
+	p.Flush()
+	leaf := p.Profile("a").Profile("b").Profile("c")
+	if leaf.baseline.nsamples > leaf.stats.nsamples {
+		t.Errorf("baseline of %d samples, more than the %d recorded", leaf.baseline.nsamples, leaf.stats.nsamples)
+	}
+}
+
+// Run with the race detector, e.g., go test -race.
+func TestObservedConcurrencyWithDeepStopAs(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+
+	p := NewProfile("p", WithComposite(), WithMemory())
+
+	const recorders, n = 4, 1000
+	var wg sync.WaitGroup
+	wg.Add(recorders + 1)
+	for r := 0; r < recorders; r++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				p.StartTimer().StopAs("a", "b", "c")
+			}
+		}()
+	}
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n/4; i++ {
+			p.EffectiveTimeObserved()
+		}
+	}()
+	wg.Wait()
+
+	if got := p.Aggregate().NSamples; got != recorders*n {
+		t.Errorf("NSamples = %d, want %d", got, recorders*n)
+	}
+}
+
+// Run with the race detector, e.g., go test -race.
+func TestCompareProfilesWithDeepStopAs(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+
+	p := NewProfile("p", WithComposite(), WithMemory())
+	q := NewProfile("q", WithMemory())
+	record(q, time.Millisecond)
+	record(q, 2*time.Millisecond)
+	p.StartTimer().StopAs("a", "b", "c")
+	p.StartTimer().StopAs("a", "b", "c")
+
+	const recorders, n = 4, 1000
+	var wg sync.WaitGroup
+	wg.Add(recorders + 1)
+	for r := 0; r < recorders; r++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				p.StartTimer().StopAs("a", "b", "c")
+			}
+		}()
+	}
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n/4; i++ {
+			CompareProfiles(p, q)
+		}
+	}()
+	wg.Wait()
+
+	if got := p.Aggregate().NSamples; got != recorders*n+2 {
+		t.Errorf("NSamples = %d, want %d", got, recorders*n+2)
+	}
+}
@@ -0,0 +1,120 @@
+package asten
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// # Config
+//
+// Contains the package-wide settings modified by the Set functions, e.g.,
+// [SetDefaultConditionName] and [SetCoresNumber]. A Config can only be
+// obtained using [SaveConfig] and applied using [RestoreConfig], e.g., to
+// restore the settings modified by a test:
+//
+//	defer asten.RestoreConfig(asten.SaveConfig())
+type Config struct {
+	defaultConditionName string
+	cores                uint64
+	logger               *slog.Logger
+	logLevel             slog.Level
+	verboseString        bool // whether ProfileSt.String returns a full dump
+	labelSanitizer       func(string) string
+	ratioPrecision       int
+	maxProfileDepth      int           // 0 means unlimited
+	idleThreshold        time.Duration // 0 means never
+	columns              []Column
+	clock                Clock
+	durationEncoding     DurationEncoding // of structured exports
+	maxNameWidth         int              // 0 means unlimited
+	exportNamespace      string           // prefix of exported names
+	glyphs               Glyphs
+	quietComposite       bool       // see SetSuppressCompositeWarnings
+	selfProfile          *ProfileSt // nil if disabled, see EnableSelfProfiling
+	overcommit           bool       // see SetAllowOvercommit
+	sampleBudget         uint64     // 0 means unlimited
+	collapseDefault      bool       // see SetCollapseDefaultCondition
+}
+
+var (
+	// config contains the current settings. It is never modified in place:
+	// setters store a modified copy, so that readers need no lock.
+	config atomic.Pointer[Config]
+	// configLock serializes the setters
+	configLock sync.Mutex
+
+	// logLevel is the level of the default logger
+	logLevel = new(slog.LevelVar)
+)
+
+func init() {
+	h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})
+
+	config.Store(&Config{
+		defaultConditionName: "base",
+		cores:                uint64(runtime.NumCPU()),
+		logger:               slog.New(h),
+		verboseString:        true,
+		labelSanitizer:       defaultLabelSanitizer,
+		ratioPrecision:       3,
+		columns: []Column{
+			ColumnProfile,
+			ColumnTimeslice,
+			ColumnTotalRuntime,
+			ColumnEffectiveRuntime,
+			ColumnMeanRuntime,
+			ColumnBranchTaken,
+			ColumnNSamples,
+		},
+		clock:  systemClock{},
+		glyphs: ASCIIGlyphs,
+	})
+}
+
+// conf returns the current settings, which must not be modified.
+func conf() *Config {
+	return config.Load()
+}
+
+// updateConfig atomically replaces the current settings with a copy modified
+// by fn.
+func updateConfig(fn func(c *Config)) {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	c := *config.Load()
+	fn(&c)
+	config.Store(&c)
+}
+
+// getLogger returns the logger used by asten (see [SetLogger]).
+func getLogger() *slog.Logger {
+	return conf().logger
+}
+
+// SaveConfig returns the current package-wide settings.
+func SaveConfig() Config {
+	c := *conf()
+	c.logLevel = logLevel.Level()
+	return c
+}
+
+// RestoreConfig restores the package-wide settings saved by [SaveConfig].
+// The zero Config is ignored.
+func RestoreConfig(c Config) {
+	if c.logger == nil {
+		getLogger().Error("invalid config, use SaveConfig to obtain one")
+		return
+	}
+
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	logLevel.Set(c.logLevel)
+	config.Store(&c)
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package asten
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// clockThreadCPUTimeID is CLOCK_THREAD_CPUTIME_ID of clock_gettime(2)
+const clockThreadCPUTimeID = 3
+
+// readThreadCPUTime returns the CPU time consumed by the calling thread, false
+// if it cannot be read.
+func readThreadCPUTime() (time.Duration, bool) {
+	var ts syscall.Timespec
+	_, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockThreadCPUTimeID, uintptr(unsafe.Pointer(&ts)), 0)
+	if errno != 0 {
+		return 0, false
+	}
+	return time.Duration(ts.Nano()), true
+}
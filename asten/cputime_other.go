@@ -0,0 +1,11 @@
+//go:build !linux
+
+package asten
+
+import "time"
+
+// readThreadCPUTime returns the CPU time consumed by the calling thread, false
+// if it cannot be read, which is always the case on this platform.
+func readThreadCPUTime() (time.Duration, bool) {
+	return 0, false
+}
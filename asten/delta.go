@@ -0,0 +1,140 @@
+package asten
+
+import (
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+)
+
+// baseline stores part of the statistics of a profile at the time of the last
+// call to PrintDelta, it is used to compute the changes since then.
+type baseline struct {
+	effectiveTime wideSum
+	nsamples      uint64
+}
+
+// PrintDelta is equivalent to [ProfileSt.Print] but, instead of cumulative
+// statistics, it displays the changes in number of samples and effective
+// runtime since the previous call to PrintDelta (or [ProfileSt.ResetDelta]).
+// Each sub-profile keeps its own baseline.
+func (p *ProfileSt) PrintDelta() {
+	p.recursiveLock()
+	cp := p.updateAndCopy()
+	p.rebase()
+	p.recursiveUnlock()
+
+	cp.printDelta()
+}
+
+// ResetDelta resets the baseline used by [ProfileSt.PrintDelta] for profile p
+// and its sub-profiles, so that the next call reports cumulative statistics.
+func (p *ProfileSt) ResetDelta() {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+
+	p.resetBaseline()
+}
+
+// PrintDelta is equivalent to [GroupSt.Print] but displays the changes since
+// the previous call to PrintDelta (see [ProfileSt.PrintDelta]).
+func (g *GroupSt) PrintDelta() {
+	g.recursiveLock()
+	cg := g.updateAndCopy()
+	for pname := range g.profiles {
+		g.profiles[pname].rebase()
+	}
+	g.recursiveUnlock()
+
+	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
+
+	tbl := newDeltaTable("group")
+	tbl.WithHeaderFormatter(headerFmt)
+
+	for _, pname := range sortedKeys(cg.profiles) {
+		tbl.AddRow(cg.profiles[pname].deltaRow(cg.name)...)
+	}
+	color.New(color.FgGreen).Add(color.Bold).Printf("\n%s Group %s (delta)\n", conf().glyphs.Group, cg.name)
+	tbl.Print()
+
+	for _, pname := range sortedKeys(cg.profiles) {
+		cg.profiles[pname].printDelta()
+	}
+}
+
+// ResetDelta resets the baseline used by [GroupSt.PrintDelta] for all the
+// profiles of group g.
+func (g *GroupSt) ResetDelta() {
+	g.recursiveLock()
+	defer g.recursiveUnlock()
+
+	for pname := range g.profiles {
+		g.profiles[pname].resetBaseline()
+	}
+}
+
+// rebase sets the baseline of p and its descendants to their current
+// statistics.
+func (p *ProfileSt) rebase() {
+	p.baseline = baseline{
+		effectiveTime: p.stats.effectiveTime,
+		nsamples:      p.stats.nsamples,
+	}
+	for spName := range p.subProfiles {
+		p.subProfiles[spName].rebase()
+	}
+}
+
+func (p *ProfileSt) resetBaseline() {
+	p.baseline = baseline{}
+	for spName := range p.subProfiles {
+		p.subProfiles[spName].resetBaseline()
+	}
+}
+
+// Equivalent to PrintDelta but does not generate copy or updates
+func (cp *ProfileSt) printDelta() {
+	headerFmt := color.New(color.FgYellow, color.Underline).SprintfFunc()
+
+	tbl := newDeltaTable()
+	tbl.WithHeaderFormatter(headerFmt)
+
+	if !cp.composite {
+		tbl.AddRow(cp.deltaRow()...)
+	} else {
+		for _, spName := range sortedKeys(cp.subProfiles) {
+			tbl.AddRow(cp.subProfiles[spName].deltaRow()...)
+		}
+	}
+	color.New(color.FgYellow).Add(color.Bold).Printf("\n%s Profile %s (delta)\n", conf().glyphs.Profile, cp.title())
+	tbl.Print()
+
+	for _, spName := range sortedKeys(cp.subProfiles) {
+		sp := cp.subProfiles[spName]
+		if sp.composite {
+			sp.printDelta()
+		}
+	}
+}
+
+func newDeltaTable(prefix ...interface{}) table.Table {
+	headers := append(prefix,
+		"profile",
+		"Δ nsamples",
+		"Δ effective runtime",
+		"Δ mean runtime",
+	)
+	return table.New(headers...)
+}
+
+func (cp *ProfileSt) deltaRow(prefix ...interface{}) []interface{} {
+	dn := int64(cp.stats.nsamples) - int64(cp.baseline.nsamples)
+	de := time.Duration(cp.stats.effectiveTime.float() - cp.baseline.effectiveTime.float())
+
+	var dm time.Duration
+	if dn > 0 {
+		dm = de / time.Duration(dn)
+	}
+
+	return append(prefix, cp.displayName(), dn, de, dm)
+}
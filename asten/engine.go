@@ -0,0 +1,162 @@
+package asten
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/rodaine/table"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// # Sample
+//
+// Represents a measurement recorded by a profile, as passed to a
+// [StatsEngine].
+type Sample struct {
+	Start  time.Time
+	End    time.Time
+	Failed bool // see Timer.StopErr
+	// Alloc is the number of bytes allocated, 0 if not tracked (see
+	// [ProfileBuilder.WithAllocTracking])
+	Alloc uint64
+	// CPUTime is the CPU time consumed, 0 if not measured (see
+	// [ProfileBuilder.WithCPUTime])
+	CPUTime time.Duration
+}
+
+// Duration returns the wall-clock duration of s.
+func (s Sample) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// exported returns the representation of s passed to the stats engines.
+func (s sample) exported() Sample {
+	return Sample{
+		Start:   s.start,
+		End:     s.end,
+		Failed:  s.failed,
+		Alloc:   s.alloc,
+		CPUTime: time.Duration(s.cpu),
+	}
+}
+
+// # StatsEngine
+//
+// Computes custom statistics over the samples of a profile, e.g., an HDR
+// histogram or the fraction of samples meeting an SLO, in place of the
+// built-in ones (see [ProfileBuilder.WithStatsEngine]).
+// Each profile owns an engine of the same type as the one given to the
+// builder: the zero value of the type, pointed to if the given engine is a
+// pointer, into which the given engine is merged. Hence the zero value must
+// be an empty engine.
+// The methods of an engine are called with its profile locked, hence they need
+// no synchronization.
+type StatsEngine interface {
+	// Record adds a sample to the statistics. Samples discarded by the warmup
+	// (see [ProfileBuilder.WithWarmup]) are not recorded.
+	Record(s Sample)
+	// Snapshot returns the current value of the statistics by name, e.g.,
+	// {"slo": 0.998}. Values are displayed by the Print functions and
+	// exported, in no particular order.
+	Snapshot() map[string]float64
+	// Merge adds the statistics of other, of the same type, to the engine,
+	// e.g., to compute the ones of a composite profile from its sub-profiles
+	Merge(other StatsEngine)
+}
+
+// emptyEngine returns the zero value of the type of e (see StatsEngine).
+func emptyEngine(e StatsEngine) StatsEngine {
+	t := reflect.TypeOf(e)
+	if t.Kind() == reflect.Pointer {
+		return reflect.New(t.Elem()).Interface().(StatsEngine)
+	}
+	return reflect.Zero(t).Interface().(StatsEngine)
+}
+
+// cloneEngine returns an independent copy of e, nil if e is nil.
+func cloneEngine(e StatsEngine) StatsEngine {
+	if e == nil {
+		return nil
+	}
+	c := emptyEngine(e)
+	c.Merge(e)
+	return c
+}
+
+// replaced returns whether the built-in statistics of s are replaced by its
+// engine, i.e., whether s is non-composite with an engine.
+func (s *profileStats) replaced() bool {
+	return s.engine != nil && !s.profile.composite
+}
+
+// EngineSnapshot returns the statistics computed by the stats engine of profile
+// p (see [ProfileBuilder.WithStatsEngine]), nil if p has no engine. For
+// composite profiles they are merged from the ones of the sub-profiles.
+func (p *ProfileSt) EngineSnapshot() map[string]float64 {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	return p.engineSnapshot()
+}
+
+// engineSnapshot returns the statistics of the engine of p, whose statistics
+// must be up to date, nil if p has no engine.
+func (p *ProfileSt) engineSnapshot() map[string]float64 {
+	if p.stats.engine == nil {
+		return nil
+	}
+	return maps.Clone(p.stats.engine.Snapshot())
+}
+
+// printEngineTable prints a table containing the statistics computed by the
+// engines of the profiles named names, if any of them has one. Statistics
+// must be up to date.
+func printEngineTable(w io.Writer, headerFmt func(format string, vals ...interface{}) string, profiles map[string]*ProfileSt, names []string) {
+	snaps := make(map[string]map[string]float64)
+	keys := make(map[string]struct{})
+	for _, name := range names {
+		snap := profiles[name].engineSnapshot()
+		if snap == nil {
+			continue
+		}
+		snaps[name] = snap
+		for k := range snap {
+			keys[k] = struct{}{}
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	sortedStats := maps.Keys(keys)
+	slices.Sort(sortedStats)
+
+	hs := []interface{}{"profile"}
+	for _, k := range sortedStats {
+		hs = append(hs, k)
+	}
+	tbl := table.New(hs...)
+	tbl.WithHeaderFormatter(headerFmt).WithWriter(w)
+
+	for _, name := range names {
+		snap, ok := snaps[name]
+		if !ok {
+			continue
+		}
+		cells := []interface{}{name}
+		for _, k := range sortedStats {
+			v, ok := snap[k]
+			if !ok {
+				cells = append(cells, "-")
+				continue
+			}
+			cells = append(cells, fmt.Sprintf("%.6g", v))
+		}
+		tbl.AddRow(cells...)
+	}
+	tbl.Print()
+}
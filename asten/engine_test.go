@@ -0,0 +1,67 @@
+package asten
+
+import (
+	"testing"
+	"time"
+)
+
+// countEngine counts the samples and sums their durations, its zero value is
+// empty
+type countEngine struct {
+	n   float64
+	sum time.Duration
+}
+
+func (e *countEngine) Record(s Sample) {
+	e.n++
+	e.sum += s.Duration()
+}
+
+func (e *countEngine) Snapshot() map[string]float64 {
+	return map[string]float64{"n": e.n, "sum_ms": float64(e.sum) / float64(time.Millisecond)}
+}
+
+func (e *countEngine) Merge(other StatsEngine) {
+	o := other.(*countEngine)
+	e.n += o.n
+	e.sum += o.sum
+}
+
+func TestStatsEngineReplacesLeafStats(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+
+	prototype := &countEngine{}
+	p := NewProfile("p", WithComposite(), WithMemory(), WithStatsEngine(prototype))
+	record(p, time.Millisecond, "a")
+	record(p, 2*time.Millisecond, "a")
+	record(p, 4*time.Millisecond, "b")
+
+	snap := p.Snapshot()
+	a, b := snap.SubProfiles[0], snap.SubProfiles[1]
+	if a.Engine["n"] != 2 || a.Engine["sum_ms"] != 3 || b.Engine["n"] != 1 || b.Engine["sum_ms"] != 4 {
+		t.Errorf("leaf engines %v and %v, want n=2 sum_ms=3 and n=1 sum_ms=4", a.Engine, b.Engine)
+	}
+	if snap.Engine["n"] != 3 || snap.Engine["sum_ms"] != 7 {
+		t.Errorf("composite engine %v, want merged n=3 sum_ms=7", snap.Engine)
+	}
+	if a.NSamples != 2 || snap.NSamples != 3 {
+		t.Errorf("nsamples %d and %d, want 2 and 3", a.NSamples, snap.NSamples)
+	}
+	if a.TotalTime != 0 || snap.TotalTime != 0 {
+		t.Errorf("built-in runtimes %v and %v computed, want 0", a.TotalTime, snap.TotalTime)
+	}
+	if n := len(p.Profile("a").Samples()); n != 0 {
+		t.Errorf("%d samples retained, want 0", n)
+	}
+	if prototype.n != 0 {
+		t.Errorf("engine given to the builder recorded %v samples, want 0", prototype.n)
+	}
+
+	if v := ColumnMeanRuntime.value(p.Profile("a")); v != notAvailable {
+		t.Errorf("mean runtime column %v, want %s", v, notAvailable)
+	}
+	if v := ColumnNSamples.value(p.Profile("a")); v != uint64(2) {
+		t.Errorf("nsamples column %v, want 2", v)
+	}
+}
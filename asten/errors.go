@@ -0,0 +1,106 @@
+package asten
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the strict variants of the methods, whose names end with
+// E (e.g., [ProfileBuilder.NewProfileE]). They can be checked using
+// [errors.Is]. The lenient methods log the corresponding message and proceed
+// instead.
+var (
+	// ErrProfileExists is returned when adding a profile whose name is already
+	// taken by another profile of the same parent profile or group
+	ErrProfileExists = errors.New("profile already exists")
+	// ErrLossyComposite is returned when making composite a profile which has
+	// recorded samples, which would be lost
+	ErrLossyComposite = errors.New("making the profile composite would discard its samples")
+	// ErrInvalidThreads is returned when setting an invalid number of threads
+	ErrInvalidThreads = errors.New("invalid number of threads")
+)
+
+// NewProfileE is equivalent to [ProfileBuilder.NewProfile] but fails instead
+// of proceeding leniently:
+//   - if the parent of pb already has a profile named pname, it is returned
+//     along with [ErrProfileExists];
+//   - if the parent profile of pb is non-composite and has recorded samples,
+//     nil and [ErrLossyComposite] are returned.
+func (pb *ProfileBuilder) NewProfileE(pname string) (*ProfileSt, error) {
+	switch {
+	case pb.parentProfile != nil:
+		pp := pb.parentProfile
+		pp.Lock()
+		defer pp.Unlock()
+
+		if !pp.composite && pp.hasSamples() {
+			return nil, fmt.Errorf("%w: %s", ErrLossyComposite, pp.getFullName())
+		}
+		if sp, ok := pp.subProfiles[pname]; ok {
+			return sp, fmt.Errorf("%w: %s", ErrProfileExists, sp.getFullName())
+		}
+		return pp.addProfile(pb.newProfile(pname)), nil
+
+	case pb.parentGroup != nil:
+		g := pb.parentGroup
+		g.Lock()
+		defer g.Unlock()
+
+		if p, ok := g.profiles[pname]; ok {
+			return p, fmt.Errorf("%w: %s in group %s", ErrProfileExists, pname, g.name)
+		}
+		return g.addProfile(pb.newProfile(pname)), nil
+	}
+
+	return pb.newProfile(pname), nil
+}
+
+// MakeCompositeE is equivalent to [ProfileSt.MakeComposite] but, if p has
+// recorded samples, it is left unchanged and [ErrLossyComposite] is returned.
+func (p *ProfileSt) MakeCompositeE() error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.composite {
+		return nil
+	}
+	if p.hasSamples() {
+		return fmt.Errorf("%w: %s", ErrLossyComposite, p.getFullName())
+	}
+
+	old := p.stats
+	old.Lock()
+	defer old.Unlock()
+
+	p.unsafeMakeComposite()
+	return nil
+}
+
+// AttachProfileE is equivalent to [GroupSt.AttachProfile] but, if g already
+// contains a different profile with the same name as p, it returns the
+// existing profile along with [ErrProfileExists].
+func (g *GroupSt) AttachProfileE(p *ProfileSt) (*ProfileSt, error) {
+	attached := g.AttachProfile(p)
+	if attached != p {
+		return attached, fmt.Errorf("%w: %s in group %s", ErrProfileExists, p.name, g.name)
+	}
+	return attached, nil
+}
+
+// WithNThreadsE is equivalent to [ProfileBuilder.WithNThreads] but, if n is 0,
+// pb is left unchanged and [ErrInvalidThreads] is returned.
+func (pb *ProfileBuilder) WithNThreadsE(n uint64) (*ProfileBuilder, error) {
+	if n == 0 {
+		return pb, fmt.Errorf("%w: %d", ErrInvalidThreads, n)
+	}
+	return pb.WithNThreads(n), nil
+}
+
+// hasSamples returns whether p has recorded any sample, p must be locked but
+// not its statistics.
+func (p *ProfileSt) hasSamples() bool {
+	p.stats.RLock()
+	defer p.stats.RUnlock()
+
+	return p.stats.count() > 0
+}
@@ -0,0 +1,120 @@
+package asten
+
+import (
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// ExplainStopAs returns the full name of the profile in which a sample would
+// be registered by calling StopAs(conds...) on a timer started by profile p
+// (see [Timer.StopAs]), given the current state of p and its descendants.
+// Nothing is recorded nor created: profiles that would be made composite or
+// created by the call are simulated. The empty string is returned if the
+// sample would be discarded because of the maximum depth (see
+// [SetMaxProfileDepth]).
+func (p *ProfileSt) ExplainStopAs(conds ...string) string {
+	if len(conds) == 0 {
+		getLogger().Error("at least one condition must be specified",
+			slog.String("profile", p.getFullName()))
+		return ""
+	}
+
+	path := explainNodeOf(p, p.path()).route(conds)
+	return strings.Join(path, " -> ")
+}
+
+// explainNode describes a profile, possibly not existing yet, while simulating
+// the routing performed by registerTimer.
+type explainNode struct {
+	profile     *ProfileSt // nil if the profile does not exist yet
+	path        []string
+	composite   bool
+	defaultCond string
+	builder     *ProfileBuilder
+}
+
+func explainNodeOf(p *ProfileSt, path []string) explainNode {
+	p.RLock()
+	defer p.RUnlock()
+
+	return explainNode{
+		profile:     p,
+		path:        path,
+		composite:   p.composite,
+		defaultCond: p.defaultConditionName(),
+		builder:     p.builder,
+	}
+}
+
+// child returns the node of the sub-profile name of n, simulating its creation
+// if it does not exist.
+func (n explainNode) child(name string) explainNode {
+	path := append(append([]string(nil), n.path...), name)
+
+	if sp, ok := n.subProfile(name); ok {
+		return explainNodeOf(sp, path)
+	}
+
+	return explainNode{
+		path:        path,
+		composite:   n.builder.composite,
+		defaultCond: n.builder.defaultConditionName(),
+		builder:     n.builder.Copy().RemoveComposition(),
+	}
+}
+
+func (n explainNode) subProfile(name string) (*ProfileSt, bool) {
+	if n.profile == nil || !n.composite {
+		return nil, false
+	}
+
+	n.profile.RLock()
+	defer n.profile.RUnlock()
+
+	sp, ok := n.profile.subProfiles[name]
+	return sp, ok
+}
+
+func (n explainNode) subProfileCount() int {
+	if n.profile == nil || !n.composite {
+		return 0
+	}
+
+	n.profile.RLock()
+	defer n.profile.RUnlock()
+
+	return len(n.profile.subProfiles)
+}
+
+// route mirrors [ProfileSt.registerTimer] and returns the path of the profile
+// in which a sample with the given conditions would be registered.
+func (n explainNode) route(conds []string) []string {
+	if conf().maxProfileDepth > 0 && len(n.path) >= conf().maxProfileDepth {
+		// mirrors limitDepth
+		if !n.composite {
+			if len(conds) != 1 || conds[0] != n.defaultCond {
+				conds = []string{n.defaultCond}
+			}
+		} else if _, ok := n.subProfile(conds[0]); !ok {
+			if _, ok := n.subProfile(n.defaultCond); ok {
+				conds = []string{n.defaultCond}
+			} else if n.subProfileCount() > 0 {
+				// discarded
+				return nil
+			} else {
+				// made non-composite
+				return n.path
+			}
+		}
+	}
+
+	if len(conds) > 1 {
+		return n.child(conds[0]).route(conds[1:])
+	}
+
+	if !n.composite && conds[0] == n.defaultCond {
+		return n.path
+	}
+	return n.child(conds[0]).route([]string{n.defaultCond})
+}
@@ -3,13 +3,15 @@ package asten
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"math"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/rodaine/table"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 	"golang.org/x/exp/slog"
 )
 
@@ -32,12 +34,22 @@ type GroupSt struct {
 
 	builder  *ProfileBuilder
 	profiles map[string]*ProfileSt
+
+	baselineName string            // reference profile, see SetBaseline
+	labels       map[string]string // see SetLabels
+	report       *ReportConfig     // nil means the global settings
 }
 
 // Group returns the group with name: gname. If a group called gname exists
 // then it will be returned otherwise a new group is created using a
 // [NewProfileBuilder] and returned.
-func Group(gname string) *GroupSt {
+// The options opts configure the builder of a newly created group (see
+// [GroupSt.Builder]), e.g.:
+//
+//	Group("db", WithMemory(), WithThreads(4))
+//
+// They are ignored, and a warning is logged, if the group already exists.
+func Group(gname string, opts ...GroupOption) *GroupSt {
 	// check if group already exists
 	ggLock.RLock()
 	g, ok := ggroups[gname]
@@ -45,36 +57,98 @@ func Group(gname string) *GroupSt {
 
 	// if found return it
 	if ok {
+		if len(opts) > 0 {
+			getLogger().Warn("group already exists, options ignored",
+				slog.String("group", gname))
+		}
 		return g
 	}
 
 	// otherwise create it
-	return newGroup(gname)
+	return newGroup(gname, opts...)
 }
 
-func newGroup(gname string) *GroupSt {
+// Groups returns all the declared groups, sorted by name. The returned slice
+// is a snapshot: groups declared afterwards are not included.
+func Groups() []*GroupSt {
+	ggLock.RLock()
+	defer ggLock.RUnlock()
+
+	gs := make([]*GroupSt, 0, len(ggroups))
+	for _, gname := range sortedKeys(ggroups) {
+		gs = append(gs, ggroups[gname])
+	}
+	return gs
+}
+
+// GroupCount returns the number of declared groups.
+func GroupCount() int {
+	ggLock.RLock()
+	defer ggLock.RUnlock()
+
+	return len(ggroups)
+}
+
+// Coverage returns the fraction of the wall-clock time elapsed since
+// programStart spent in profiled code, i.e., the sum of the effective runtimes
+// of all the declared groups divided by the elapsed time. A value close to 1
+// means that nearly everything is profiled, while a low value reveals large
+// unprofiled sections. Profiles shared by several groups (see
+// [GroupSt.AttachProfile]) are counted once per group, hence the result may
+// exceed 1. The group of [EnableSelfProfiling] is not included.
+// 0 is returned if programStart is not in the past.
+func Coverage(programStart time.Time) float64 {
+	elapsed := conf().clock.Now().Sub(programStart)
+	if elapsed <= 0 {
+		return 0
+	}
+
+	var effective wideSum
+	for _, g := range Groups() {
+		if g.name == selfGroupName {
+			continue
+		}
+
+		g.recursiveLock()
+		g.update()
+		effective.add(g.stats.effectiveTime)
+		g.recursiveUnlock()
+	}
+	return effective.ratio(wideOf(uint64(elapsed)))
+}
+
+func newGroup(gname string, opts ...GroupOption) *GroupSt {
 	// check that group does not already exist
 	ggLock.Lock()
 	defer ggLock.Unlock()
 
 	if g, ok := ggroups[gname]; ok {
-		logger.Warn("attempt to redeclare group detected",
+		getLogger().Warn("attempt to redeclare group detected",
 			slog.String("group", gname))
 		return g
 	}
 
-	// create group
+	// create and register group
+	g := NewUnregisteredGroup(gname, opts...)
+	ggroups[gname] = g
+
+	return g
+}
+
+// NewUnregisteredGroup returns a new group named gname, configured by opts as
+// by [Group], which is not declared: it cannot be obtained using [Group] and
+// is not included in [Groups], [PrintGroups] nor in the exports. It is meant
+// for transient or test-scoped profiling, the group being garbage collected
+// once unreferenced. Its name may be the same as a declared group.
+func NewUnregisteredGroup(gname string, opts ...GroupOption) *GroupSt {
 	g := &GroupSt{
 		RWMutex:  &sync.RWMutex{},
 		name:     gname,
 		profiles: make(map[string]*ProfileSt),
 	}
-	g.builder = NewProfileBuilder().WithParentGroup(g)
+	g.builder = NewProfileBuilder().WithOptions(opts...).WithParentGroup(g)
 	g.stats = newGroupStats(g)
 
-	// register group
-	ggroups[gname] = g
-
 	return g
 }
 
@@ -101,30 +175,94 @@ func (g *GroupSt) Profile(pname string) *ProfileSt {
 //
 //	Group(default_condition_name).Profile(pname)
 func Profile(pname string) *ProfileSt {
-	return Group(default_condition_name).Profile(pname)
+	return Group(conf().defaultConditionName).Profile(pname)
 }
 
 func (g *GroupSt) addProfile(p *ProfileSt) *ProfileSt {
 	// if subprofile was already declared return it, discarding the new one
 	if tmp, ok := g.profiles[p.name]; ok {
-		logger.Warn("attempt to redeclare profile detected",
+		getLogger().Warn("attempt to redeclare profile detected",
 			slog.String("profile", p.getFullName()))
 		return tmp
 	}
 
 	p.parent = nil
+	p.addGroup(g)
+	g.profiles[p.name] = p
+	g.stats.valid.Store(false)
+
+	return p
+}
+
+// AttachProfile adds the existing profile p to group g without copying it or
+// changing its parent, so that p is shared by g and the group (or profile) it
+// already belongs to: samples recorded by p are reported by both.
+// Since group statistics are computed from their profiles, the runtimes of
+// shared profiles are counted once per group in [PrintGroups].
+// If g already contains a profile with the same name as p, p is not attached
+// and the existing profile is returned.
+func (g *GroupSt) AttachProfile(p *ProfileSt) *ProfileSt {
+	g.Lock()
+	defer g.Unlock()
+
+	if tmp, ok := g.profiles[p.name]; ok {
+		if tmp != p {
+			getLogger().Warn("attempt to attach profile with the name of an existing one",
+				slog.String("profile", p.getFullName()),
+				slog.String("group", g.name))
+		}
+		return tmp
+	}
+
+	p.Lock()
+	p.addGroup(g)
+	p.Unlock()
+
 	g.profiles[p.name] = p
+	g.stats.valid.Store(false)
 
 	return p
 }
 
+// addGroup adds g to the groups containing profile p, which must be locked
+// unless it is not shared yet.
+func (p *ProfileSt) addGroup(g *GroupSt) {
+	gs := append(append([]*GroupSt(nil), p.memberOf()...), g)
+	p.groups.Store(&gs)
+}
+
+// memberOf returns the groups containing profile p. The returned slice must
+// not be modified.
+func (p *ProfileSt) memberOf() []*GroupSt {
+	if gs := p.groups.Load(); gs != nil {
+		return *gs
+	}
+	return nil
+}
+
 // StartTimer is equivalent to calling:
 //
 //	g.Profile(default_condition_name).StartTimer()
 //
-// (see [SetDefaultConditionName]).
+// where default_condition_name is the default condition of the group builder
+// (see [GroupSt.WithDefaultCondition] and [SetDefaultConditionName]).
 func (g *GroupSt) StartTimer() *Timer {
-	return g.Profile(default_condition_name).StartTimer()
+	g.RLock()
+	name := g.builder.defaultConditionName()
+	g.RUnlock()
+
+	return g.Profile(name).StartTimer()
+}
+
+// WithDefaultCondition modifies and returns g, setting the default condition
+// of its builder to name (see [ProfileBuilder.WithDefaultCondition]).
+// It affects [GroupSt.StartTimer] and the profiles created afterwards.
+func (g *GroupSt) WithDefaultCondition(name string) *GroupSt {
+	g.Lock()
+	defer g.Unlock()
+
+	g.builder.WithDefaultCondition(name)
+	return g
 }
 
 // Builder returns a pointer to the builder used to generate new profiles
@@ -143,21 +281,21 @@ func (g *GroupSt) String() string {
 	b := bytes.NewBufferString("")
 
 	b.WriteString(fmt.Sprintf("[Group %s]\n", g.name))
+	for _, k := range sortedKeys(g.labels) {
+		b.WriteString(fmt.Sprintf("label %s: %s\n", k, g.labels[k]))
+	}
 
 	s := g.builder.String()
-	s = strings.Replace("\t"+s, "\n", "\n\t", -1)
-	s = s[:len(s)-1]
+	s = indent(s)
 
 	b.WriteString(fmt.Sprintf("builder: \n%s\n", s))
 
-	s = strings.Replace("\t"+g.stats.String(), "\n", "\n\t", -1)
-	s = s[:len(s)-1]
+	s = indent(g.stats.String())
 	b.WriteString(s)
 
 	b.WriteString("profiles:\n")
-	for _, subp := range g.profiles {
-		s = strings.Replace("\t"+subp.String(), "\n", "\n\t", -1)
-		s = s[:len(s)-1]
+	for _, pname := range sortedKeys(g.profiles) {
+		s = indent(g.profiles[pname].String())
 		b.WriteString(s)
 	}
 
@@ -171,46 +309,121 @@ func (g *GroupSt) Print() {
 	cg := g.updateAndCopy()
 	g.recursiveUnlock()
 
+	cg.print(color.Output)
+}
+
+// Fprint is equivalent to [GroupSt.Print] but writes the tables to w.
+func (g *GroupSt) Fprint(w io.Writer) {
+	g.recursiveLock()
+	cg := g.updateAndCopy()
+	g.recursiveUnlock()
+
+	cg.print(w)
+}
+
+// Equivalent to Fprint but does not generate copy or updates
+func (cg *GroupSt) print(w io.Writer) {
+	rc := cg.reportConfig()
+	columns := rc.Columns
+	pnames := rc.order(cg.profiles, sortedKeys(cg.profiles))
 	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
 
-	tbl := table.New(
-		"group",
-		"profile",
-		"timeslice",
-		"total runtime",
-		"effective runtime",
-		"mean runtime",
-		"branch taken",
-		"nsamples",
-	)
-	tbl.WithHeaderFormatter(headerFmt)
+	hs := headers(columns, "group")
+	if cg.baselineName != "" {
+		hs = append(hs, "vs baseline")
+	}
+	tbl := table.New(hs...)
+	tbl.WithHeaderFormatter(headerFmt).WithWriter(w).WithWidthFunc(visibleWidth)
 
-	for spName := range cg.profiles {
+	for _, spName := range pnames {
 		sp := cg.profiles[spName]
-		tbl.AddRow(
-			g.name,
-			sp.getFullName(),
-			math.Floor(sp.stats.timeslice*1000)/1000,
-			time.Duration(sp.stats.totalTime),
-			time.Duration(sp.stats.effectiveTime),
-			time.Duration(sp.stats.meanTime),
-			sp.stats.taken,
-			sp.stats.nsamples)
-	}
-	color.New(color.FgGreen).Add(color.Bold).Printf("\n\u24bc Group %s\n", g.name)
+		cells := row(sp, columns, cg.name)
+		if cg.baselineName != "" {
+			cells = append(cells, cg.vsBaseline(sp))
+		}
+		tbl.AddRow(sloColored(sp, cells)...)
+	}
+	color.New(color.FgGreen).Add(color.Bold).Fprintf(w, "\n%s Group %s\n", conf().glyphs.Group, cg.name)
 	tbl.Print()
+	printEngineTable(w, headerFmt, cg.profiles, pnames)
+	for _, warning := range cg.warnings() {
+		color.New(color.FgYellow).Fprintf(w, "warning: %s\n", warning)
+	}
 
-	for profileName := range cg.profiles {
+	for _, profileName := range pnames {
 		p := cg.profiles[profileName]
-		p.print()
+		p.print(w, rc)
 	}
 }
 
 // PrintGroups generates and prints in a recursive manner tables containing info
 // regarding all the declared groups and their profiles.
+// Each group is locked only for the time needed to copy it, printing is
+// performed on the copies.
 func PrintGroups() {
-	ggLock.Lock()
-	defer ggLock.Unlock()
+	printGroups(Groups())
+}
+
+// PrintGroupsWhere is equivalent to [PrintGroups] but only the groups having
+// all the labels in match (see [GroupSt.SetLabels]) are printed.
+func PrintGroupsWhere(match map[string]string) {
+	var gs []*GroupSt
+	for _, g := range Groups() {
+		if g.hasLabels(match) {
+			gs = append(gs, g)
+		}
+	}
+	printGroups(gs)
+}
+
+// StartAutoPrint starts a goroutine calling [PrintGroups] every interval, e.g.,
+// for local debugging, and returns a function stopping it:
+//
+//	stop := StartAutoPrint(5 * time.Second)
+//	defer stop()
+//
+// The returned function waits for a print in progress to complete, so that
+// nothing is printed once it returns. It can be called more than once.
+func StartAutoPrint(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		getLogger().Error("auto print interval must be > 0")
+		return func() {}
+	}
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				PrintGroups()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(quit)
+		})
+		<-done
+	}
+}
+
+// printGroups prints the tables of the groups gs, sorted by name.
+func printGroups(gs []*GroupSt) {
+	cgs := make(map[string]*GroupSt, len(gs))
+	for _, g := range gs {
+		g.recursiveLock()
+		cgs[g.name] = g.updateAndCopy()
+		g.recursiveUnlock()
+	}
 
 	headerFmt := color.New(color.FgWhite, color.Underline).SprintfFunc()
 
@@ -222,29 +435,150 @@ func PrintGroups() {
 	)
 	tbl.WithHeaderFormatter(headerFmt)
 
-	cgs := make(map[string]*GroupSt)
-	for gName := range ggroups {
-		g := ggroups[gName]
-		g.recursiveLock()
-		cgs[gName] = g.updateAndCopy()
-		g.recursiveUnlock()
-	}
-
-	for profileName := range cgs {
-		p := cgs[profileName]
+	for _, gName := range sortedKeys(cgs) {
+		cg := cgs[gName]
 		tbl.AddRow(
-			p.name,
-			time.Duration(p.stats.totalTime),
-			time.Duration(p.stats.effectiveTime),
-			p.stats.nsamples)
+			cg.name,
+			cg.stats.totalTime.duration(),
+			cg.stats.effectiveTime.duration(),
+			cg.stats.nsamples)
 	}
-	color.New(color.FgWhite).Add(color.Bold).Printf("\n\uf111 Groups\n")
+	color.New(color.FgWhite).Add(color.Bold).Printf("\n%s Groups\n", conf().glyphs.Groups)
 	tbl.Print()
 
-	for gName := range cgs {
-		g := cgs[gName]
-		g.Print()
+	for _, gName := range sortedKeys(cgs) {
+		cgs[gName].print(color.Output)
+	}
+}
+
+// LeafPaths returns the paths of the non-composite profiles of group g and of
+// the non-composite descendants of its composite profiles, sorted. Each path
+// lists the names of the profiles from the one belonging to g to the leaf,
+// e.g., ["p", "status=500", "db"].
+func (g *GroupSt) LeafPaths() [][]string {
+	g.recursiveLock()
+	defer g.recursiveUnlock()
+
+	var paths [][]string
+	g.forEachLeafPath(func(path []string, _ *ProfileSt) {
+		paths = append(paths, path)
+	})
+	return paths
+}
+
+// forEachLeafPath calls fn, sorted by path, on each non-composite profile of g
+// and on each non-composite descendant of its composite profiles, along with
+// its path relative to g (see [GroupSt.LeafPaths]).
+func (g *GroupSt) forEachLeafPath(fn func(path []string, leaf *ProfileSt)) {
+	for _, pname := range sortedKeys(g.profiles) {
+		g.profiles[pname].forEachLeafPath([]string{pname}, fn)
+	}
+}
+
+// forEachLeafPath calls fn on the non-composite descendants of p (or on p
+// itself if non-composite) along with their paths, each prefixed by prefix.
+func (p *ProfileSt) forEachLeafPath(prefix []string, fn func(path []string, leaf *ProfileSt)) {
+	if !p.composite {
+		fn(prefix, p)
+		return
+	}
+	for _, spName := range sortedKeys(p.subProfiles) {
+		path := append(append([]string(nil), prefix...), spName)
+		p.subProfiles[spName].forEachLeafPath(path, fn)
+	}
+}
+
+// SetLabels sets the labels of group g, e.g., {"layer": "db"}, used to
+// organize groups (see [PrintGroupsWhere]) and included in their snapshots
+// (see [GroupSt.Snapshot]). Previous labels are discarded.
+func (g *GroupSt) SetLabels(labels map[string]string) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.labels = maps.Clone(labels)
+}
+
+// Labels returns a copy of the labels of group g (see [GroupSt.SetLabels]).
+func (g *GroupSt) Labels() map[string]string {
+	g.RLock()
+	defer g.RUnlock()
+
+	return maps.Clone(g.labels)
+}
+
+// hasLabels returns whether g has all the labels in match.
+func (g *GroupSt) hasLabels(match map[string]string) bool {
+	g.RLock()
+	defer g.RUnlock()
+
+	for k, v := range match {
+		if lv, ok := g.labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// LogLine emits, using l, one structured record per profile of group g (and
+// per descendant of composite profiles) with the attributes:
+//
+//	group, profile, effective_ns, mean_ns, n, timeslice
+//
+// where profile is the full name of the profile. If l is nil the asten logger
+// is used (see [SetLogger]). Records are emitted at level Info.
+func (g *GroupSt) LogLine(l *slog.Logger) {
+	if l == nil {
+		l = getLogger()
+	}
+
+	g.recursiveLock()
+	cg := g.updateAndCopy()
+	g.recursiveUnlock()
+
+	for _, pname := range sortedKeys(cg.profiles) {
+		cg.profiles[pname].logLine(l, cg.name)
+	}
+}
+
+func (cp *ProfileSt) logLine(l *slog.Logger, group string) {
+	l.Info("asten profile",
+		slog.String("group", group),
+		slog.String("profile", cp.getFullName()),
+		slog.Uint64("effective_ns", cp.stats.effectiveTime.nanos()),
+		slog.Int64("mean_ns", int64(cp.stats.meanTime)),
+		slog.Uint64("n", cp.stats.count()),
+		slog.Float64("timeslice", roundRatio(cp.stats.timeslice)))
+
+	for _, spName := range sortedKeys(cp.subProfiles) {
+		cp.subProfiles[spName].logLine(l, group)
+	}
+}
+
+// GeometricMeanRuntime returns the geometric mean of the mean runtimes of the
+// profiles belonging to group g. Profiles whose mean runtime is zero are
+// skipped.
+// Unlike the arithmetic mean, the geometric mean is meaningful when comparing
+// normalized values such as speedup factors.
+func (g *GroupSt) GeometricMeanRuntime() time.Duration {
+	g.recursiveLock()
+	defer g.recursiveUnlock()
+	g.update()
+
+	var logSum float64
+	var n int
+	for pname := range g.profiles {
+		mean := g.profiles[pname].stats.meanTime
+		if mean == 0 {
+			continue
+		}
+		logSum += math.Log(float64(mean))
+		n++
+	}
+
+	if n == 0 {
+		return 0
 	}
+	return time.Duration(math.Exp(logSum / float64(n)))
 }
 
 func (g *GroupSt) copy() *GroupSt {
@@ -254,36 +588,108 @@ func (g *GroupSt) copy() *GroupSt {
 		stats:    g.stats.copy(),
 		builder:  g.builder.Copy(),
 		profiles: make(map[string]*ProfileSt),
+
+		baselineName: g.baselineName,
+		labels:       maps.Clone(g.labels),
+		report:       g.report,
 	}
 
+	// the copy is a detached tree, not referring to the original group
+	cp.builder.WithParentGroup(cp)
+	cp.stats.group = cp
+
 	for pname := range g.profiles {
 		cp.profiles[pname] = g.profiles[pname].copy()
+		cp.profiles[pname].groups.Store(&[]*GroupSt{cp})
 	}
 
 	return cp
 }
 
+// SetBaseline designates the profile of group g named pname as the reference
+// of the group: the tables printed for g include a "vs baseline" column
+// containing the ratio between the effective runtime of each profile and the
+// one of the baseline, e.g., 0.5 for a profile twice as fast.
+// The baseline does not need to exist yet, an empty pname removes it.
+func (g *GroupSt) SetBaseline(pname string) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.baselineName = pname
+}
+
+// vsBaseline returns the ratio between the effective runtime of p and the one
+// of the baseline of g, N/A if not available. Statistics must be up to date.
+func (g *GroupSt) vsBaseline(p *ProfileSt) interface{} {
+	b, ok := g.profiles[g.baselineName]
+	if !ok || b.stats.effectiveTime.isZero() {
+		return notAvailable
+	}
+	return roundRatio(p.stats.effectiveTime.ratio(b.stats.effectiveTime))
+}
+
+// Flush recomputes the statistics of group g and of its profiles, so that they
+// are valid and current, without printing anything.
+func (g *GroupSt) Flush() {
+	g.recursiveLock()
+	defer g.recursiveUnlock()
+
+	g.update()
+}
+
 func (g *GroupSt) updateAndCopy() *GroupSt {
 	g.update()
 	return g.copy()
 }
 
+// recursiveLock locks g, its profiles and their descendants. Profiles are
+// locked in the order of their lock keys, which is the same for all groups,
+// so that groups sharing profiles (see [GroupSt.AttachProfile]) cannot
+// deadlock.
 func (g *GroupSt) recursiveLock() {
 	g.Lock()
 	g.stats.Lock()
-	for pname := range g.profiles {
-		g.profiles[pname].recursiveLock()
+	for _, p := range g.lockRoots() {
+		p.recursiveLock()
 	}
 }
 
 func (g *GroupSt) recursiveUnlock() {
-	for pname := range g.profiles {
-		g.profiles[pname].recursiveUnlock()
+	for _, p := range g.lockRoots() {
+		p.recursiveUnlock()
 	}
 	g.stats.Unlock()
 	g.Unlock()
 }
 
+// lockRoots returns the profiles of g, which must be locked, that are not
+// descendants of other profiles of g, sorted by lock key. Locking their
+// subtrees locks each profile of g exactly once.
+func (g *GroupSt) lockRoots() []*ProfileSt {
+	type root struct {
+		p   *ProfileSt
+		key lockKey
+	}
+	all := make([]root, 0, len(g.profiles))
+	for _, p := range g.profiles {
+		all = append(all, root{p, p.lockKey()})
+	}
+	slices.SortFunc(all, func(a, b root) bool {
+		return a.key.less(b.key)
+	})
+
+	// the descendants of a profile immediately follow it
+	var roots []*ProfileSt
+	var last lockKey
+	for i, r := range all {
+		if i > 0 && last.contains(r.key) {
+			continue
+		}
+		roots, last = append(roots, r.p), r.key
+	}
+	return roots
+}
+
 func (g *GroupSt) update() {
 	for pname := range g.profiles {
 		g.profiles[pname].update()
@@ -0,0 +1,295 @@
+package asten
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+)
+
+// checkDetached checks that the copy cp, child of cparent in the copied tree,
+// and its descendants only refer to profiles of the copy.
+func checkDetached(t *testing.T, cp, orig, cparent *ProfileSt) {
+	t.Helper()
+
+	name := cp.getFullName()
+	if cp == orig {
+		t.Errorf("%s: copy is the original profile", name)
+	}
+	if cp.parent != cparent {
+		t.Errorf("%s: parent not in the copy", name)
+	}
+	if cp.builder.parentProfile != cp || cp.builder.parentGroup != nil {
+		t.Errorf("%s: builder generates profiles outside the copy", name)
+	}
+	if cp.stats.profile != cp {
+		t.Errorf("%s: statistics refer to another profile", name)
+	}
+	for spName, sp := range cp.subProfiles {
+		checkDetached(t, sp, orig.subProfiles[spName], cp)
+	}
+}
+
+func TestGroupCopyDetached(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+
+	g := NewUnregisteredGroup("g", WithComposite())
+	record(g.Profile("p"), time.Millisecond, "a", "x")
+	record(g.Profile("p"), time.Millisecond, "b")
+
+	g.recursiveLock()
+	cg := g.updateAndCopy()
+	g.recursiveUnlock()
+
+	if cg.builder.parentGroup != cg || cg.builder.parentProfile != nil {
+		t.Error("group builder generates profiles outside the copy")
+	}
+	if cg.stats.group != cg {
+		t.Error("group statistics refer to another group")
+	}
+	for pname, cp := range cg.profiles {
+		if gs := cp.memberOf(); len(gs) != 1 || gs[0] != cg {
+			t.Errorf("%s: groups not in the copy", pname)
+		}
+		checkDetached(t, cp, g.profiles[pname], nil)
+	}
+
+	cg.builder.NewProfile("new")
+	cp := cg.profiles["p"]
+	cp.builder.NewProfile("c")
+	cp.subProfiles["a"].builder.NewProfile("y")
+
+	if _, ok := g.profiles["new"]; ok {
+		t.Error("profile generated by the copy added to the original group")
+	}
+	if _, ok := cg.profiles["new"]; !ok {
+		t.Error("profile generated by the copy not added to it")
+	}
+	p := g.profiles["p"]
+	if n := len(p.subProfiles); n != 2 {
+		t.Errorf("original profile has %d sub-profiles, want 2", n)
+	}
+	if n := len(p.subProfiles["a"].subProfiles); n != 1 {
+		t.Errorf("original sub-profile has %d sub-profiles, want 1", n)
+	}
+	if n := len(cp.subProfiles); n != 3 {
+		t.Errorf("copied profile has %d sub-profiles, want 3", n)
+	}
+}
+
+func TestGroupPrintAfterRecord(t *testing.T) {
+	restoreConfig(t)
+	noColor := color.NoColor
+	color.NoColor = true
+	t.Cleanup(func() { color.NoColor = noColor })
+
+	g := NewUnregisteredGroup("g")
+	record(g.Profile("a"), time.Millisecond)
+	record(g.Profile("b"), time.Millisecond)
+
+	var before, after bytes.Buffer
+	g.Fprint(&before)
+	record(g.Profile("a"), 2*time.Millisecond)
+	g.Fprint(&after)
+
+	if !strings.Contains(before.String(), "0.5") {
+		t.Errorf("first print lacks timeslice 0.5:\n%s", before.String())
+	}
+	if !strings.Contains(after.String(), "0.75") {
+		t.Errorf("second print does not reflect the new sample:\n%s", after.String())
+	}
+	if snap := g.Snapshot(); snap.NSamples != 3 || snap.TotalTime != 4*time.Millisecond {
+		t.Errorf("group has %d samples lasting %v, want 3 lasting 4ms", snap.NSamples, snap.TotalTime)
+	}
+}
+
+// TestAttachWhileRecording is meant to be run with the race detector: the
+// groups of a profile are read by its descendants recording samples.
+func TestAttachWhileRecording(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+
+	root := NewProfile("root", WithComposite())
+	leaf := root.Profile("leaf")
+
+	const n = 2000
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			leaf.StartTimer().StopAs("c")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			NewUnregisteredGroup(fmt.Sprint("g", i)).AttachProfile(root)
+		}
+	}()
+	wg.Wait()
+
+	if gs := root.memberOf(); len(gs) != n {
+		t.Errorf("root attached to %d groups, want %d", len(gs), n)
+	}
+	if snap := root.Snapshot(); snap.NSamples != n {
+		t.Errorf("%d samples, want %d", snap.NSamples, n)
+	}
+}
+
+func TestSelfProfiling(t *testing.T) {
+	restoreConfig(t)
+
+	EnableSelfProfiling()
+	lw := Group(selfGroupName).Profile("lock wait")
+	before := lw.Snapshot().NSamples
+
+	p := NewProfile("p")
+	for i := 0; i < 3; i++ {
+		p.StartTimer().Stop()
+	}
+	if n := lw.Snapshot().NSamples - before; n != 6 {
+		t.Errorf("%d lock waits recorded, want 6", n)
+	}
+	for _, cond := range []string{"route", "stats"} {
+		if !lw.HasConditionPath(cond) {
+			t.Errorf("no lock wait recorded as %s", cond)
+		}
+	}
+	if n := p.Snapshot().NSamples; n != 3 {
+		t.Errorf("NSamples = %d, want 3", n)
+	}
+
+	DisableSelfProfiling()
+	before = lw.Snapshot().NSamples
+	p.StartTimer().Stop()
+	if n := lw.Snapshot().NSamples; n != before {
+		t.Errorf("%d lock waits recorded after disabling, want 0", n-before)
+	}
+}
+
+func TestAutoPrintStop(t *testing.T) {
+	restoreConfig(t)
+	SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var out syncBuffer
+	output, tableWriter, noColor := color.Output, table.DefaultWriter, color.NoColor
+	color.Output, table.DefaultWriter, color.NoColor = &out, &out, true
+	t.Cleanup(func() { color.Output, table.DefaultWriter, color.NoColor = output, tableWriter, noColor })
+
+	stop := StartAutoPrint(time.Millisecond)
+	for deadline := time.Now().Add(5 * time.Second); !strings.Contains(out.String(), "Groups"); {
+		if time.Now().After(deadline) {
+			stop()
+			t.Fatal("groups not printed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stop()
+	printed := out.String()
+	time.Sleep(10 * time.Millisecond)
+	if out.String() != printed {
+		t.Error("groups printed after stop returned")
+	}
+	// stopping again neither blocks nor panics
+	stop()
+
+	StartAutoPrint(0)()
+}
+
+func TestReportConfigOrder(t *testing.T) {
+	restoreConfig(t)
+	SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	g := NewUnregisteredGroup("g", WithMemory())
+	for name, d := range map[string]time.Duration{
+		"a": 2 * time.Millisecond,
+		"b": 5 * time.Millisecond,
+		"c": 2 * time.Millisecond,
+		"d": time.Millisecond,
+	} {
+		record(g.Profile(name), d)
+	}
+	g.Profile("empty")
+
+	g.SetReportConfig(ReportConfig{SortBy: SortByP99, Descending: true, HideEmpty: true})
+	// invalid settings are ignored
+	g.SetReportConfig(ReportConfig{SortBy: numSortKeys})
+
+	g.Snapshot()
+	rc := g.reportConfig()
+	if rc.SortBy != SortByP99 || !rc.Descending || rc.Columns == nil {
+		t.Fatalf("report config %+v, want sorted by descending p99 with the global columns", rc)
+	}
+	// ties are sorted by name
+	got := rc.order(g.profiles, sortedKeys(g.profiles))
+	if want := []string{"b", "a", "c", "d"}; !slices.Equal(got, want) {
+		t.Errorf("order %v, want %v", got, want)
+	}
+
+	g.ResetReportConfig()
+	got = g.reportConfig().order(g.profiles, sortedKeys(g.profiles))
+	if want := []string{"a", "b", "c", "d", "empty"}; !slices.Equal(got, want) {
+		t.Errorf("order %v after reset, want %v", got, want)
+	}
+}
+
+// TestSharedProfilesLockOrder is meant to be run with the race detector: the
+// groups share profiles that they name in different orders, one of them
+// being the sub-profile of another.
+func TestSharedProfilesLockOrder(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+
+	g1 := NewUnregisteredGroup("g1", WithComposite())
+	x := g1.Profile("x")
+	s := g1.Profile("y").Profile("a")
+	g2 := NewUnregisteredGroup("g2")
+	g2.AttachProfile(s)
+	g2.AttachProfile(x)
+	g2.AttachProfile(g1.Profile("y"))
+
+	const n = 500
+	var wg sync.WaitGroup
+	wg.Add(3)
+	for _, g := range []*GroupSt{g1, g2} {
+		g := g
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				g.Flush()
+			}
+		}()
+	}
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			s.StartTimer().StopAs("c")
+			x.StartTimer().Stop()
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("deadlock flushing groups sharing profiles")
+	}
+
+	if got := g2.Profile("a").Aggregate().NSamples; got != n {
+		t.Errorf("NSamples = %d, want %d", got, n)
+	}
+}
@@ -0,0 +1,240 @@
+package asten
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// jsonProfile is the JSON encoding of a ProfileSnapshot, see ProfileSt.ToJSON
+type jsonProfile struct {
+	Name        string        `json:"name"`
+	Desc        string        `json:"description,omitempty"`
+	Location    string        `json:"location,omitempty"` // see WithCallerInfo
+	Unit        string        `json:"unit"`               // of the durations, see SetExportDurationEncoding
+	Total       interface{}   `json:"total"`
+	Effective   interface{}   `json:"effective"`
+	Mean        interface{}   `json:"mean"`
+	NSamples    uint64        `json:"nsamples"`
+	Timeslice   float64       `json:"timeslice"`
+	Taken       float64       `json:"taken"`
+	Scale       float64       `json:"scale,omitempty"` // omitted if 1, see SetDurationScale
+	SubProfiles []jsonProfile `json:"subprofiles,omitempty"`
+	// custom statistics, see WithStatsEngine
+	Engine map[string]float64 `json:"engine,omitempty"`
+}
+
+// newJSONProfile returns the JSON encoding of snap and its sub-profiles.
+func newJSONProfile(snap ProfileSnapshot, de DurationEncoding) jsonProfile {
+	jp := jsonProfile{
+		Name:      exportName(snap.Name),
+		Desc:      snap.Description,
+		Location:  snap.Location,
+		Unit:      de.unit(),
+		Total:     de.encode(snap.TotalTime),
+		Effective: de.encode(snap.EffectiveTime),
+		Mean:      de.encode(snap.MeanTime),
+		NSamples:  snap.NSamples,
+		Timeslice: roundRatio(snap.Timeslice),
+		Taken:     roundRatio(snap.Taken),
+		Scale:     encodeScale(snap.Scale),
+		Engine:    snap.Engine,
+	}
+	for _, sp := range snap.SubProfiles {
+		jp.SubProfiles = append(jp.SubProfiles, newJSONProfile(sp, de))
+	}
+	return jp
+}
+
+// ToJSON returns the JSON encoding of the snapshot of profile p and of its
+// descendants (see [ProfileSt.Snapshot]), regardless of the groups p belongs
+// to, e.g.:
+//
+//	{"name":"query","unit":"ns","total":1200,"effective":1200,"mean":600,"nsamples":2,"timeslice":1,"taken":1,"subprofiles":[...]}
+//
+// Durations are encoded as set using [SetExportDurationEncoding] and names are
+// sanitized as set using [SetLabelSanitizer] and prefixed as set using
+// [SetExportNamespace].
+func (p *ProfileSt) ToJSON() ([]byte, error) {
+	var b bytes.Buffer
+	if err := p.WriteJSON(&b); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// WriteJSON is equivalent to [ProfileSt.ToJSON] but writes the encoding to w,
+// followed by a newline.
+func (p *ProfileSt) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	// keep the arrows of the full names readable
+	enc.SetEscapeHTML(false)
+	return enc.Encode(newJSONProfile(p.Snapshot(), conf().durationEncoding))
+}
+
+// jsonGroup is the JSON encoding of a GroupSnapshot, see GroupSt.ToJSON
+type jsonGroup struct {
+	Name      string            `json:"name"`
+	Unit      string            `json:"unit"` // of the durations, see SetExportDurationEncoding
+	Total     interface{}       `json:"total"`
+	Effective interface{}       `json:"effective"`
+	NSamples  uint64            `json:"nsamples"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Profiles  []jsonProfile     `json:"profiles,omitempty"`
+}
+
+// newJSONGroup returns the JSON encoding of snap and its profiles.
+func newJSONGroup(snap GroupSnapshot, de DurationEncoding) jsonGroup {
+	jg := jsonGroup{
+		Name:      exportName(snap.Name),
+		Unit:      de.unit(),
+		Total:     de.encode(snap.TotalTime),
+		Effective: de.encode(snap.EffectiveTime),
+		NSamples:  snap.NSamples,
+		Labels:    snap.Labels,
+	}
+	for _, p := range snap.Profiles {
+		jg.Profiles = append(jg.Profiles, newJSONProfile(p, de))
+	}
+	return jg
+}
+
+// ToJSON returns the JSON encoding of the snapshot of group g, its profiles and
+// their descendants (see [GroupSt.Snapshot]), e.g.:
+//
+//	{"name":"db","unit":"ns","total":1200,"effective":1200,"nsamples":2,"profiles":[...]}
+//
+// where profiles are encoded as by [ProfileSt.ToJSON]. It can be decoded using
+// [UnmarshalGroupJSON].
+func (g *GroupSt) ToJSON() ([]byte, error) {
+	var b bytes.Buffer
+	if err := g.WriteJSON(&b); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// WriteJSON is equivalent to [GroupSt.ToJSON] but writes the encoding to w,
+// followed by a newline.
+func (g *GroupSt) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(newJSONGroup(g.Snapshot(), conf().durationEncoding))
+}
+
+// UnmarshalGroupJSON decodes the snapshot of a group encoded by
+// [GroupSt.ToJSON], e.g., to compare the statistics of a previous run stored
+// on disk. Durations are decoded according to their unit, regardless of
+// [SetExportDurationEncoding], and the namespace set using [SetExportNamespace]
+// is removed from the names.
+// Ratios are decoded as encoded, i.e., truncated to the precision set using
+// [SetRatioPrecision]: encoding the decoded snapshot again yields the same
+// JSON.
+func UnmarshalGroupJSON(data []byte) (GroupSnapshot, error) {
+	var jg jsonGroup
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// preserve the precision of integer nanoseconds
+	dec.UseNumber()
+	if err := dec.Decode(&jg); err != nil {
+		return GroupSnapshot{}, err
+	}
+
+	snap := GroupSnapshot{
+		Name:     strings.TrimPrefix(jg.Name, conf().exportNamespace),
+		NSamples: jg.NSamples,
+		Labels:   jg.Labels,
+	}
+	var err error
+	if snap.TotalTime, err = decodeDuration(jg.Total, jg.Unit); err != nil {
+		return GroupSnapshot{}, err
+	}
+	if snap.EffectiveTime, err = decodeDuration(jg.Effective, jg.Unit); err != nil {
+		return GroupSnapshot{}, err
+	}
+	for _, jp := range jg.Profiles {
+		p, err := jp.snapshot()
+		if err != nil {
+			return GroupSnapshot{}, err
+		}
+		snap.Profiles = append(snap.Profiles, p)
+	}
+	return snap, nil
+}
+
+// snapshot returns the snapshot encoded by jp, decoded by UnmarshalGroupJSON.
+func (jp jsonProfile) snapshot() (ProfileSnapshot, error) {
+	snap := ProfileSnapshot{
+		Name:        strings.TrimPrefix(jp.Name, conf().exportNamespace),
+		Description: jp.Desc,
+		Location:    jp.Location,
+		NSamples:    jp.NSamples,
+		Timeslice:   jp.Timeslice,
+		Taken:       jp.Taken,
+		Scale:       1,
+		Engine:      jp.Engine,
+	}
+	if jp.Scale != 0 {
+		snap.Scale = jp.Scale
+	}
+	var err error
+	if snap.TotalTime, err = decodeDuration(jp.Total, jp.Unit); err != nil {
+		return ProfileSnapshot{}, err
+	}
+	if snap.EffectiveTime, err = decodeDuration(jp.Effective, jp.Unit); err != nil {
+		return ProfileSnapshot{}, err
+	}
+	if snap.MeanTime, err = decodeDuration(jp.Mean, jp.Unit); err != nil {
+		return ProfileSnapshot{}, err
+	}
+	for _, jsp := range jp.SubProfiles {
+		sp, err := jsp.snapshot()
+		if err != nil {
+			return ProfileSnapshot{}, err
+		}
+		snap.SubProfiles = append(snap.SubProfiles, sp)
+	}
+	return snap, nil
+}
+
+// encodeScale returns the encoding of the duration scale of a snapshot, 0,
+// i.e., omitted, if its durations are not scaled.
+func encodeScale(scale float64) float64 {
+	if scale == 1 {
+		return 0
+	}
+	return scale
+}
+
+// decodeDuration returns the duration v, decoded as a json.Number, expressed
+// in unit (see DurationEncoding.unit).
+func decodeDuration(v interface{}, unit string) (time.Duration, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("invalid duration %v", v)
+	}
+
+	var scale float64
+	switch unit {
+	case "ns":
+		if d, err := n.Int64(); err == nil {
+			return time.Duration(d), nil
+		}
+		scale = 1
+	case "ms":
+		scale = float64(time.Millisecond)
+	case "s":
+		scale = float64(time.Second)
+	default:
+		return 0, fmt.Errorf("invalid duration unit %q", unit)
+	}
+
+	f, err := n.Float64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(math.Round(f * scale)), nil
+}
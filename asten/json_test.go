@@ -0,0 +1,117 @@
+package asten
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// encodeGroupSnapshot returns the JSON encoding of snap, as by GroupSt.ToJSON.
+func encodeGroupSnapshot(t *testing.T, snap GroupSnapshot) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(newJSONGroup(snap, conf().durationEncoding)); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+}
+
+func TestGroupJSONRoundTrip(t *testing.T) {
+	restoreConfig(t)
+
+	g := NewUnregisteredGroup("db", WithComposite())
+	g.SetLabels(map[string]string{"env": "test"})
+	query := g.Profile("query")
+	query.SetDescription("SELECT statements")
+	record(query, 1234567*time.Nanosecond, "hit")
+	record(query, 2*time.Millisecond, "hit")
+	record(query, 7654321*time.Nanosecond, "miss")
+	insert := g.Profile("insert")
+	insert.SetDurationScale(0.5)
+	record(insert, 333*time.Microsecond)
+
+	for _, enc := range []DurationEncoding{DurationNanos, DurationFloatSeconds, DurationMillis} {
+		SetExportDurationEncoding(enc)
+
+		data, err := g.ToJSON()
+		if err != nil {
+			t.Fatalf("encoding %d: %v", enc, err)
+		}
+		snap, err := UnmarshalGroupJSON(data)
+		if err != nil {
+			t.Fatalf("encoding %d: %v", enc, err)
+		}
+		if again := encodeGroupSnapshot(t, snap); !bytes.Equal(again, data) {
+			t.Errorf("encoding %d: round trip changed the JSON\nfirst:  %s\nsecond: %s", enc, data, again)
+		}
+		for _, p := range snap.Profiles {
+			want := 1.0
+			if p.Name == "insert" {
+				want = 0.5
+			}
+			if p.Scale != want {
+				t.Errorf("encoding %d: %s decoded with scale %v, want %v", enc, p.Name, p.Scale, want)
+			}
+		}
+	}
+}
+
+func TestGroupJSONLocation(t *testing.T) {
+	g := NewUnregisteredGroup("g", WithCallerInfo())
+	record(g.Profile("p"), time.Millisecond)
+
+	data, err := g.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := UnmarshalGroupJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := g.Profile("p").Snapshot().Location
+	if want == "" {
+		t.Fatal("location not recorded")
+	}
+	if got := snap.Profiles[0].Location; got != want {
+		t.Errorf("decoded location %q, want %q", got, want)
+	}
+}
+
+func TestGroupJSONSanitizedNames(t *testing.T) {
+	restoreConfig(t)
+
+	g := NewUnregisteredGroup("my db", WithComposite())
+	record(g.Profile("query;1"), time.Millisecond, "a b")
+
+	data, err := g.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := UnmarshalGroupJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Name != "my_db" {
+		t.Errorf("group name %q, want %q", snap.Name, "my_db")
+	}
+	p := snap.Profiles[0]
+	if p.Name != "query_1" || p.SubProfiles[0].Name != "query_1 -> a_b" {
+		t.Errorf("profile names %q and %q, want %q and %q",
+			p.Name, p.SubProfiles[0].Name, "query_1", "query_1 -> a_b")
+	}
+
+	SetLabelSanitizer(strings.ToUpper)
+	SetExportNamespace("svc_")
+	if data, err = g.ToJSON(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"name":"svc_QUERY;1 -> A B"`)) {
+		t.Errorf("custom sanitizer not applied: %s", data)
+	}
+}
@@ -0,0 +1,197 @@
+package asten
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slog"
+)
+
+// jsonlRecord is a line written by StreamJSONL
+type jsonlRecord struct {
+	Time      time.Time   `json:"time"`
+	Group     string      `json:"group"`
+	Path      []string    `json:"path"`
+	Desc      string      `json:"description,omitempty"`
+	Location  string      `json:"location,omitempty"` // see WithCallerInfo
+	Unit      string      `json:"unit"`               // of the durations, see SetExportDurationEncoding
+	Total     interface{} `json:"total"`
+	Effective interface{} `json:"effective"`
+	Mean      interface{} `json:"mean"`
+	NSamples  uint64      `json:"nsamples"`
+	Timeslice float64     `json:"timeslice"`
+	Taken     float64     `json:"taken"`
+	Scale     float64     `json:"scale,omitempty"` // omitted if 1, see SetDurationScale
+	// of the group, see GroupSt.SetLabels
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// StreamJSONL writes to w, every interval, one JSON object per line for each
+// non-composite profile (see [GroupSt.LeafPaths]) of every declared group,
+// containing the time of the snapshot, the group, the path of the profile, its
+// current statistics and the labels of the group (see [GroupSt.SetLabels]),
+// e.g.:
+//
+//	{"time":"...","group":"db","path":["query","select"],"unit":"ns","total":1200,"effective":1200,"mean":600,"nsamples":2,"timeslice":0.5,"taken":0.5,"labels":{"layer":"db"}}
+//
+// Durations are encoded as set using [SetExportDurationEncoding] and names are
+// sanitized as set using [SetLabelSanitizer].
+//
+// StreamJSONL blocks until stop is closed, in which case it returns nil, or
+// until writing to w fails, in which case it returns the error. It is meant to
+// be run in its own goroutine, which terminates with it. It returns an error
+// immediately if interval is not positive.
+func StreamJSONL(w io.Writer, interval time.Duration, stop <-chan struct{}) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid stream interval %v, must be > 0", interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			if err := writeJSONL(enc); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeJSONL encodes the records of all the groups using enc.
+func writeJSONL(enc *json.Encoder) error {
+	now := conf().clock.Now()
+
+	for _, g := range Groups() {
+		// write without holding the locks, w may be slow
+		for _, r := range g.jsonlRecords(now) {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonlRecords returns the records of the non-composite profiles of g at time
+// now, see StreamJSONL.
+func (g *GroupSt) jsonlRecords(now time.Time) []jsonlRecord {
+	de := conf().durationEncoding
+	var records []jsonlRecord
+
+	g.recursiveLock()
+	defer g.recursiveUnlock()
+
+	g.update()
+	// shared by the records, which are not modified
+	labels := maps.Clone(g.labels)
+	g.forEachLeafPath(func(path []string, leaf *ProfileSt) {
+		snap := leaf.snapshot()
+		records = append(records, jsonlRecord{
+			Time:      now,
+			Group:     exportName(g.name),
+			Path:      exportPath(path),
+			Desc:      snap.Description,
+			Location:  snap.Location,
+			Unit:      de.unit(),
+			Total:     de.encode(snap.TotalTime),
+			Effective: de.encode(snap.EffectiveTime),
+			Mean:      de.encode(snap.MeanTime),
+			NSamples:  snap.NSamples,
+			Timeslice: roundRatio(snap.Timeslice),
+			Taken:     roundRatio(snap.Taken),
+			Scale:     encodeScale(snap.Scale),
+			Labels:    labels,
+		})
+	})
+	return records
+}
+
+// SetExportNamespace sets a prefix prepended to the names written by the
+// structured exporters, e.g., "myservice_" makes [StreamJSONL] write the group
+// "db" as "myservice_db" and [GroupSt.WritePprof] write the profile "query" as
+// "myservice_query". This avoids name clashes when several services export to
+// the same backend. The Print functions are not affected.
+// The default value is the empty string.
+func SetExportNamespace(prefix string) {
+	updateConfig(func(c *Config) {
+		c.exportNamespace = prefix
+	})
+}
+
+// exportName returns name, which may be the full name of a profile (see
+// getFullName), with each of its components sanitized (see
+// [SetLabelSanitizer]), prefixed by the namespace set using
+// [SetExportNamespace].
+func exportName(name string) string {
+	names := strings.Split(name, " -> ")
+	return conf().exportNamespace + strings.Join(exportPath(names), " -> ")
+}
+
+// exportPath sanitizes the names of path in place (see [SetLabelSanitizer]) and
+// returns it.
+func exportPath(path []string) []string {
+	for i := range path {
+		path[i] = sanitizeLabel(path[i])
+	}
+	return path
+}
+
+// # DurationEncoding
+//
+// Represents how durations are written by the structured exporters, such as
+// [StreamJSONL] (see [SetExportDurationEncoding]).
+type DurationEncoding int
+
+const (
+	// DurationNanos encodes durations as integer nanoseconds, the default
+	DurationNanos DurationEncoding = iota
+	// DurationFloatSeconds encodes durations as floating point seconds
+	DurationFloatSeconds
+	// DurationMillis encodes durations as floating point milliseconds
+	DurationMillis
+)
+
+// SetExportDurationEncoding sets how durations are written by the structured
+// exporters. The default is [DurationNanos].
+func SetExportDurationEncoding(enc DurationEncoding) {
+	if enc < DurationNanos || enc > DurationMillis {
+		getLogger().Error("invalid duration encoding",
+			slog.Int("encoding", int(enc)))
+		return
+	}
+
+	updateConfig(func(c *Config) {
+		c.durationEncoding = enc
+	})
+}
+
+// encode returns d encoded as specified by e.
+func (e DurationEncoding) encode(d time.Duration) interface{} {
+	switch e {
+	case DurationFloatSeconds:
+		return d.Seconds()
+	case DurationMillis:
+		return float64(d) / float64(time.Millisecond)
+	}
+	return d.Nanoseconds()
+}
+
+// unit returns the unit of the durations encoded as specified by e.
+func (e DurationEncoding) unit() string {
+	switch e {
+	case DurationFloatSeconds:
+		return "s"
+	case DurationMillis:
+		return "ms"
+	}
+	return "ns"
+}
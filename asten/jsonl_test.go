@@ -0,0 +1,64 @@
+package asten
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStreamJSONLInvalidInterval(t *testing.T) {
+	var b bytes.Buffer
+	stop := make(chan struct{})
+	close(stop)
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := StreamJSONL(&b, interval, stop); err == nil {
+			t.Errorf("interval %v: no error", interval)
+		}
+	}
+	if b.Len() > 0 {
+		t.Errorf("written %q, want nothing", b.String())
+	}
+}
+
+func TestJSONLRecordLabels(t *testing.T) {
+	g := NewUnregisteredGroup("db", WithComposite())
+	g.SetLabels(map[string]string{"layer": "storage"})
+	record(g.Profile("query"), time.Millisecond, "select")
+	record(g.Profile("insert"), time.Millisecond)
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, r := range g.jsonlRecords(time.Unix(0, 0)) {
+		if err := enc.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := json.NewDecoder(&b)
+	var paths [][]string
+	for dec.More() {
+		var r jsonlRecord
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Group != "db" || r.Labels["layer"] != "storage" || len(r.Labels) != 1 {
+			t.Errorf("record %v: group %q with labels %v, want %q with %v",
+				r.Path, r.Group, r.Labels, "db", g.Labels())
+		}
+		paths = append(paths, r.Path)
+	}
+	if len(paths) != 2 {
+		t.Errorf("decoded paths %v, want [insert] and [query select]", paths)
+	}
+
+	g.SetLabels(nil)
+	b.Reset()
+	if err := enc.Encode(g.jsonlRecords(time.Unix(0, 0))[0]); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b.Bytes(), []byte(`"labels"`)) {
+		t.Errorf("labels encoded without labels: %s", b.Bytes())
+	}
+}
@@ -0,0 +1,169 @@
+package asten
+
+import "time"
+
+// # ProfileOption
+//
+// ProfileOption configures the characteristics of the profiles generated by a
+// [ProfileBuilder], e.g., when passed to [NewProfile]. It is a functional
+// alternative to the methods of ProfileBuilder.
+type ProfileOption func(pb *ProfileBuilder)
+
+// GroupOption configures the builder of a group (see [Group]). Any
+// ProfileOption is a GroupOption: it applies to the profiles of the group.
+type GroupOption = ProfileOption
+
+// NewProfile returns a new profile named pname, not belonging to any group nor
+// profile, whose characteristics are set by opts. For example:
+//
+//	p := NewProfile("parse", WithMemory(), WithThreads(4))
+//
+// Without options it is equivalent to:
+//
+//	NewProfileBuilder().NewProfile(pname)
+func NewProfile(pname string, opts ...ProfileOption) *ProfileSt {
+	return NewProfileBuilder().WithOptions(opts...).NewProfile(pname)
+}
+
+// WithOptions modifies and returns pb, applying opts to it in order.
+func (pb *ProfileBuilder) WithOptions(opts ...ProfileOption) *ProfileBuilder {
+	for _, opt := range opts {
+		opt(pb)
+	}
+	return pb
+}
+
+// WithMemory makes profiles memory full (see [ProfileBuilder.AddMemory]).
+func WithMemory() ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.AddMemory()
+	}
+}
+
+// WithThreads makes profiles multi-threaded with n threads (see
+// [ProfileBuilder.WithNThreads]).
+func WithThreads(n uint64) ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithNThreads(n)
+	}
+}
+
+// WithComposite makes profiles composite (see [ProfileBuilder.AddComposition]).
+func WithComposite() ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.AddComposition()
+	}
+}
+
+// WithDefaultCondition sets the default condition of profiles (see
+// [ProfileBuilder.WithDefaultCondition]).
+func WithDefaultCondition(name string) ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithDefaultCondition(name)
+	}
+}
+
+// WithApproxPercentiles makes profiles estimate the percentiles qs (see
+// [ProfileBuilder.WithApproxPercentiles]).
+func WithApproxPercentiles(qs ...float64) ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithApproxPercentiles(qs...)
+	}
+}
+
+// WithWarmup makes profiles discard their first n samples (see
+// [ProfileBuilder.WithWarmup]).
+func WithWarmup(n uint64) ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithWarmup(n)
+	}
+}
+
+// WithAllocTracking makes profiles track allocations (see
+// [ProfileBuilder.WithAllocTracking]).
+func WithAllocTracking() ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithAllocTracking()
+	}
+}
+
+// WithTimerTimeout makes profiles warn about timers not stopped within d (see
+// [ProfileBuilder.WithTimerTimeout]).
+func WithTimerTimeout(d time.Duration) ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithTimerTimeout(d)
+	}
+}
+
+// WithCallerInfo makes profiles record where they are created (see
+// [ProfileBuilder.WithCallerInfo]).
+func WithCallerInfo() ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithCallerInfo()
+	}
+}
+
+// WithParallelismFloor makes profiles divide their runtime by the number of
+// threads only after minSamples samples (see
+// [ProfileBuilder.WithParallelismFloor]).
+func WithParallelismFloor(minSamples uint64) ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithParallelismFloor(minSamples)
+	}
+}
+
+// WithDecay makes the mean runtime of memoryless profiles an exponentially
+// weighted moving average (see [ProfileBuilder.WithDecay]).
+func WithDecay(halfLife time.Duration) ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithDecay(halfLife)
+	}
+}
+
+// WithMemoryIfSlowerThan makes memoryless profiles become memory full once
+// their mean runtime exceeds d (see [ProfileBuilder.WithMemoryIfSlowerThan]).
+func WithMemoryIfSlowerThan(d time.Duration) ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithMemoryIfSlowerThan(d)
+	}
+}
+
+// WithDescription sets the description of profiles (see
+// [ProfileBuilder.WithDescription]).
+func WithDescription(s string) ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithDescription(s)
+	}
+}
+
+// WithGCAttribution makes profiles count the samples spanning a garbage
+// collection cycle (see [ProfileBuilder.WithGCAttribution]).
+func WithGCAttribution() ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithGCAttribution()
+	}
+}
+
+// WithRollup makes composite profiles aggregate the statistics of their
+// sub-profiles using fn (see [ProfileBuilder.WithRollup]).
+func WithRollup(fn RollupFunc) ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithRollup(fn)
+	}
+}
+
+// WithCPUTime makes profiles measure the CPU time of their timers (see
+// [ProfileBuilder.WithCPUTime]).
+func WithCPUTime() ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithCPUTime()
+	}
+}
+
+// WithStatsEngine makes profiles compute the custom statistics of engine in
+// place of the built-in ones (see [ProfileBuilder.WithStatsEngine]).
+func WithStatsEngine(engine StatsEngine) ProfileOption {
+	return func(pb *ProfileBuilder) {
+		pb.WithStatsEngine(engine)
+	}
+}
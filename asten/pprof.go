@@ -0,0 +1,151 @@
+package asten
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// WritePprof writes to w the statistics of group g in the gzip-compressed
+// protobuf format of pprof (profile.proto), to be inspected using:
+//
+//	go tool pprof <file>
+//
+// Each non-composite profile becomes a sample whose stack is its path, e.g.,
+// "p -> status:500 -> db" becomes the stack db, status:500, p. Its values are
+// the number of samples and the effective runtime in nanoseconds ("wall").
+// Names are sanitized as set using [SetLabelSanitizer] and the names of the
+// profiles of g are prefixed as set using [SetExportNamespace].
+func (g *GroupSt) WritePprof(w io.Writer) error {
+	g.recursiveLock()
+	g.update()
+
+	var pb pprofBuilder
+	pb.init()
+	for _, pname := range sortedKeys(g.profiles) {
+		g.profiles[pname].forEachLeaf(func(leaf *ProfileSt) {
+			path := exportPath(leaf.path())
+			path[0] = conf().exportNamespace + path[0]
+			pb.addSample(path, int64(leaf.stats.count()), int64(leaf.stats.effectiveTime.duration()))
+		})
+	}
+	g.recursiveUnlock()
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(pb.encode()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// pprofBuilder builds a pprof profile (see
+// https://github.com/google/pprof/blob/main/proto/profile.proto) whose
+// functions are the names of the profiles, each having a single location.
+type pprofBuilder struct {
+	strings   []string
+	stringIDs map[string]int64
+	functions map[string]uint64 // function, and location, ID by name
+	samples   []byte            // encoded samples
+}
+
+func (b *pprofBuilder) init() {
+	b.stringIDs = make(map[string]int64)
+	b.functions = make(map[string]uint64)
+	b.str("") // the first string must be empty
+}
+
+// str returns the index of s in the string table, adding it if needed.
+func (b *pprofBuilder) str(s string) int64 {
+	if id, ok := b.stringIDs[s]; ok {
+		return id
+	}
+	id := int64(len(b.strings))
+	b.strings = append(b.strings, s)
+	b.stringIDs[s] = id
+	return id
+}
+
+// addSample adds a sample whose stack is path, from the root to the leaf.
+func (b *pprofBuilder) addSample(path []string, count, nanos int64) {
+	var locs []byte
+	for i := len(path) - 1; i >= 0; i-- {
+		id, ok := b.functions[path[i]]
+		if !ok {
+			id = uint64(len(b.functions) + 1)
+			b.functions[path[i]] = id
+			b.str(path[i])
+		}
+		locs = binary.AppendUvarint(locs, id)
+	}
+
+	var vals []byte
+	vals = binary.AppendUvarint(vals, uint64(count))
+	vals = binary.AppendUvarint(vals, uint64(nanos))
+
+	var s []byte
+	s = protoBytes(s, 1, locs) // location_id, packed
+	s = protoBytes(s, 2, vals) // value, packed
+	b.samples = protoBytes(b.samples, 2, s)
+}
+
+// encode returns the encoded Profile message.
+func (b *pprofBuilder) encode() []byte {
+	var m []byte
+
+	// sample_type
+	m = protoBytes(m, 1, valueType(b.str("samples"), b.str("count")))
+	m = protoBytes(m, 1, valueType(b.str("wall"), b.str("nanoseconds")))
+
+	m = append(m, b.samples...)
+
+	for _, name := range sortedKeys(b.functions) {
+		id := b.functions[name]
+
+		var line []byte
+		line = protoVarint(line, 1, id) // function_id
+
+		var loc []byte
+		loc = protoVarint(loc, 1, id)  // id
+		loc = protoBytes(loc, 4, line) // line
+		m = protoBytes(m, 4, loc)
+
+		var fn []byte
+		fn = protoVarint(fn, 1, id)                  // id
+		fn = protoVarint(fn, 2, uint64(b.str(name))) // name
+		fn = protoVarint(fn, 3, uint64(b.str(name))) // system_name
+		m = protoBytes(m, 5, fn)
+	}
+
+	// the string table must be complete, it is written last
+	periodType := valueType(b.str("wall"), b.str("nanoseconds"))
+	for _, s := range b.strings {
+		m = protoBytes(m, 6, []byte(s))
+	}
+
+	m = protoVarint(m, 9, uint64(time.Now().UnixNano())) // time_nanos
+	m = protoBytes(m, 11, periodType)                    // period_type
+	m = protoVarint(m, 12, 1)                            // period
+
+	return m
+}
+
+func valueType(typ, unit int64) []byte {
+	var vt []byte
+	vt = protoVarint(vt, 1, uint64(typ))
+	vt = protoVarint(vt, 2, uint64(unit))
+	return vt
+}
+
+// protoVarint appends to b the field number field with varint value v.
+func protoVarint(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+// protoBytes appends to b the field number field with length-delimited value v.
+func protoBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
@@ -3,13 +3,15 @@ package asten
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"math"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatih/color"
-	"github.com/rodaine/table"
+	"golang.org/x/exp/slices"
 	"golang.org/x/exp/slog"
 )
 
@@ -20,8 +22,15 @@ import (
 //   - non-composite
 //   - composite: it represent a collection of subprofiles.
 //   - single-threaded
-//   - multi-threaded: in this case the effective runtime of the profile will
-//     simply be divided by the number of threads specified.
+//   - multi-threaded: in this case the effective runtime of the profile models
+//     the wall-clock time under perfect parallelism with the number of threads
+//     specified, i.e., max(total runtime / threads, longest sample), whether
+//     the profile is memory full or memoryless.
+//     The number of threads only applies to non-composite profiles, each using
+//     its own: the effective runtime of a composite profile is the sum of the
+//     ones of its sub-profiles, hence parallelism is never applied twice. The
+//     number of threads of a composite profile is only inherited by the
+//     sub-profiles it creates.
 //   - memory full: it keeps memory of the start and the end of all recorded samples. This
 //     avoids updating the statistics each time a sample is recirded.
 //   - memoryless: when recording a sample statistics are updated and the sample discarded.
@@ -31,16 +40,44 @@ import (
 type ProfileSt struct {
 	*sync.RWMutex
 	name string
+	id   uint64 // unique, orders the locks of distinct trees, see lockKey
 
 	parent *ProfileSt
+	// groups containing the profile, see GroupSt.AttachProfile. The slice is
+	// replaced rather than modified since the descendants of the profile read
+	// it while recording samples, without holding its lock
+	groups atomic.Pointer[[]*GroupSt]
 
 	composite   bool
 	builder     *ProfileBuilder
 	subProfiles map[string]*ProfileSt
 
-	nThreads uint64
-	memory   bool
-	stats    *profileStats
+	nThreads         uint64
+	memory           bool
+	defaultCondition string
+	approxQuantiles  []float64
+	warmup           uint64
+	trackAlloc       bool
+	timerTimeout     time.Duration
+	callerInfo       bool
+	parallelismFloor uint64
+	decayHalfLife    time.Duration
+	compacted        bool // samples discarded, see CompactSamples
+	memoryThreshold  time.Duration
+	description      string // see SetDescription
+	gcAttribution    bool
+	rollup           RollupFunc // nil means summation, see WithRollup
+	cpuTime          bool
+	statsEngine      StatsEngine   // prototype of the engines, see WithStatsEngine
+	sloTarget        time.Duration // 0 means no objective, see SetSLO
+	sloQuantile      float64
+	location         string // file:line where p was created, see WithCallerInfo
+	stats            *profileStats
+	baseline         baseline
+
+	callbacks []func(d time.Duration, conds []string)
+	inactive  atomic.Bool   // see SetActive
+	scale     atomic.Uint64 // bits of the duration scale, 0 if unscaled, see SetDurationScale
 }
 
 // Profile returns the sub-profile named pname belonging to profile p.
@@ -63,14 +100,13 @@ func (p *ProfileSt) addProfile(sp *ProfileSt) *ProfileSt {
 	// adding a profile to a non composite one will cause it to be converted
 	// samples registered while profile was not composite will be lost
 	if !p.composite {
-		logger.Warn("making profile composite, previous samples will be lost",
-			slog.String("profile", p.getFullName()))
+		warnComposite(p, "making profile composite, previous samples will be lost")
 		p.unsafeMakeComposite()
 	}
 
 	// if subprofile was already declared return it, discarding the new one
 	if tmp, ok := p.subProfiles[sp.name]; ok {
-		logger.Warn("attempt to redeclare profile detected",
+		getLogger().Warn("attempt to redeclare profile detected",
 			slog.String("profile", sp.getFullName()), slog.String("function", "addprofile"))
 		return tmp
 	}
@@ -82,20 +118,72 @@ func (p *ProfileSt) addProfile(sp *ProfileSt) *ProfileSt {
 	return sp
 }
 
+// hasCyclicAncestry returns true if p is its own ancestor, in which case
+// traversing its ancestors, e.g., by getFullName, never ends.
+func (p *ProfileSt) hasCyclicAncestry() bool {
+	visited := make(map[*ProfileSt]bool)
+	for q := p; q != nil; q = q.parent {
+		if visited[q] {
+			return true
+		}
+		visited[q] = true
+	}
+	return false
+}
+
 // MakeComposite transforms profile p from non-composite to composite. Any
 // sample recorded while p was non-composite will be lost.
 func (p *ProfileSt) MakeComposite() *ProfileSt {
-	p.recursiveLock()
-	defer p.recursiveUnlock()
+	p.Lock()
+	defer p.Unlock()
+
+	if p.composite {
+		return p
+	}
+
+	// non-composite profiles have no sub-profiles to lock. The statistics are
+	// replaced, hence the ones locked must be unlocked rather than p.stats.
+	old := p.stats
+	old.Lock()
+	defer old.Unlock()
+
+	return p.unsafeMakeComposite()
+}
+
+// MakeCompositePreserving is equivalent to [ProfileSt.MakeComposite] but,
+// instead of being discarded, the samples recorded while p was non-composite
+// are moved to a new sub-profile named bucket.
+// If bucket is empty the default condition of p is used (see
+// [ProfileBuilder.WithDefaultCondition]).
+func (p *ProfileSt) MakeCompositePreserving(bucket string) *ProfileSt {
+	p.Lock()
+	defer p.Unlock()
 
 	if p.composite {
 		return p
 	}
+	if bucket == "" {
+		bucket = p.defaultConditionName()
+	}
+
+	// the sub-profile inherits the statistics (and characteristics) of p
+	sp := p.builder.newProfile(bucket)
+	sp.memory = p.memory
+	sp.nThreads = p.nThreads
+
+	old := p.stats
+	old.Lock()
+	old.profile = sp
+	sp.stats = old
+	old.Unlock()
+
 	p.composite = true
 	p.subProfiles = make(map[string]*ProfileSt)
-	p.stats.Unlock()
 	p.stats = newProfileStats(p)
-	p.stats.Lock()
+
+	sp.parent = p
+	p.subProfiles[bucket] = sp
+	p.stats.invalidate()
 
 	return p
 }
@@ -104,274 +192,1254 @@ func (p *ProfileSt) unsafeMakeComposite() *ProfileSt {
 	if p.composite {
 		return p
 	}
-	p.composite = true
-	p.subProfiles = make(map[string]*ProfileSt)
-	p.stats = newProfileStats(p)
+	p.composite = true
+	p.subProfiles = make(map[string]*ProfileSt)
+	p.stats.release()
+	p.stats = newProfileStats(p)
+	// ancestors and groups must forget the discarded samples
+	p.stats.invalidate()
+
+	return p
+}
+
+// Builder returns a pointer to the builder used to generate new sub-profiles
+// for profile p.
+func (p *ProfileSt) Builder() *ProfileBuilder {
+	p.RLock()
+	if p.composite {
+		defer p.RUnlock()
+		return p.builder
+	}
+	p.RUnlock()
+
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.composite {
+		warnComposite(p, "requested builder of non composite profile. Profile will be made composite, all previous samples will be lost")
+		// as in MakeComposite, the statistics locked are the replaced ones
+		old := p.stats
+		old.Lock()
+		defer old.Unlock()
+		p.unsafeMakeComposite()
+	}
+	return p.builder
+}
+
+// BuilderReadOnly returns the builder used to generate new sub-profiles for
+// profile p and whether p is composite. Unlike [ProfileSt.Builder] it never
+// modifies p: for non-composite profiles the builder is returned together
+// with false, and p is not made composite.
+func (p *ProfileSt) BuilderReadOnly() (*ProfileBuilder, bool) {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.builder, p.composite
+}
+
+// SetBuilder sets the builder used by profile p to generate new profiles to pb.
+// The builder is refused, as by [ProfileBuilder.WithParentProfile], if the
+// ancestors of p form a cycle.
+func (p *ProfileSt) SetBuilder(pb *ProfileBuilder) {
+	p.Lock()
+	defer p.Unlock()
+
+	if pb.WithParentProfile(p).parentProfile != p {
+		return
+	}
+	p.builder = pb
+}
+
+// StartTimer starts and returns a [Timer] relative to profile p.
+// If p has a timer timeout (see [ProfileBuilder.WithTimerTimeout]) the timer is
+// watched until stopped.
+// If p tracks allocations (see [ProfileBuilder.WithAllocTracking]) the number of
+// bytes allocated so far is recorded as well, likewise for the number of
+// completed GC cycles if p attributes GCs (see
+// [ProfileBuilder.WithGCAttribution]) and for the CPU time of the thread if p
+// measures it (see [ProfileBuilder.WithCPUTime]).
+func (p *ProfileSt) StartTimer() *Timer {
+	t := &Timer{profile: p}
+
+	if p.timerTimeout > 0 {
+		timerWatchdog.watch(t, p.timerTimeout)
+	}
+
+	if p.trackAlloc {
+		t.tracksAlloc = true
+		t.startAlloc = readAllocBytes()
+	}
+
+	if p.gcAttribution {
+		t.tracksGC = true
+		t.startGC = readGCCycles()
+	}
+
+	if p.cpuTime {
+		t.startCPU, t.tracksCPU = readThreadCPUTime()
+	}
+
+	t.start = conf().clock.Now()
+	return t
+}
+
+// TimeFunc times the execution of fn, recording the sample as [Timer.Stop]
+// would. It is equivalent to TimeFuncAs(fn).
+func (p *ProfileSt) TimeFunc(fn func()) {
+	p.TimeFuncAs(fn)
+}
+
+// TimeFuncAs times the execution of fn, recording the sample as
+// [Timer.StopAs] would with the given conditions, or as [Timer.Stop] if none
+// is specified.
+// If fn panics the sample is recorded anyway, counted as a failure (see
+// [ProfileSt.ErrorRate]), before the panic reaches the caller.
+func (p *ProfileSt) TimeFuncAs(fn func(), conds ...string) {
+	t := p.StartTimer()
+	completed := false
+
+	defer func() {
+		// runs while panicking as well, without recovering
+		t.failed = !completed
+		if len(conds) == 0 {
+			t.Stop()
+			return
+		}
+		t.StopAs(conds...)
+	}()
+
+	fn()
+	completed = true
+}
+
+// Incr records an untimed sample in profile p, as if a timer were stopped
+// with the given conditions (see [Timer.StopAs]), or with the default
+// condition if none is specified. It allows counting events, e.g., cache
+// hits, whose frequencies are expressed by the number of samples and the
+// branch taken ratios.
+// Untimed samples are not accounted for by the runtime statistics, e.g., the
+// mean runtime or the percentiles, and callbacks registered by
+// [ProfileSt.OnSample] are not called.
+func (p *ProfileSt) Incr(conds ...string) {
+	if len(conds) == 0 {
+		conds = []string{p.defaultConditionName()}
+	}
+
+	leaf, wait := p.lockLeaf(conds)
+	if leaf == nil {
+		return
+	}
+
+	leaf.stats.registerEvent()
+
+	leaf.Unlock()
+	leaf.stats.Unlock()
+	wait.record()
+}
+
+func (p *ProfileSt) registerTimer(t *Timer) {
+	leaf, wait := p.lockLeaf(t.conds)
+	if leaf == nil {
+		return
+	}
+
+	sample := t.sample()
+	leaf.stats.registerSample(sample)
+
+	leaf.Unlock()
+	leaf.stats.Unlock()
+	wait.record()
+
+	leaf.notify(sample, t.conds)
+}
+
+// route follows conds starting from p, making profiles composite and creating
+// sub-profiles as needed, and returns the non-composite profile in which a
+// sample with such conditions must be registered (see [Timer.StopAs]).
+// The returned profile is locked, nil is returned if the sample must be
+// discarded (see [ProfileSt.SetActive]).
+func (p *ProfileSt) route(conds []string) *ProfileSt {
+	if !p.isActive() {
+		return nil
+	}
+
+	if conf().maxProfileDepth > 0 && p.depth() >= conf().maxProfileDepth {
+		var ok bool
+		if conds, ok = p.limitDepth(conds); !ok {
+			return nil
+		}
+	}
+
+	if len(conds) > 1 {
+		return p.Profile(conds[0]).route(conds[1:])
+	}
+
+	p.Lock()
+
+	defaultCond := p.defaultConditionName()
+	cond := conds[0]
+	if !p.composite {
+		// if profile is not composite but a condition is specified then the
+		// profile is made composite and the timer is passed to a new subprofile
+		if cond != defaultCond {
+
+			warnComposite(p, "making profile composite, previous samples will be lost")
+			p.unsafeMakeComposite()
+
+			p.Unlock()
+
+			return p.Profile(cond).route([]string{defaultCond})
+		}
+
+		return p
+	}
+	p.Unlock()
+
+	return p.Profile(cond).route([]string{defaultCond})
+}
+
+// RecordBatch registers in profile p a sample for each span, as if it was
+// measured by a timer started by p at span.Start and stopped at span.End
+// calling StopAs(span.Conds...) (see [Timer.StopAs]), or Stop if span.Conds is
+// empty.
+// Spans with the same conditions are registered together, acquiring the lock
+// of the destination profile and invalidating its statistics only once.
+func (p *ProfileSt) RecordBatch(spans []Span) {
+	type batch struct {
+		conds   []string
+		samples []sample
+	}
+
+	// group spans by conditions, preserving the order of first appearance
+	var batches []*batch
+	index := make(map[string]*batch)
+	for _, span := range spans {
+		conds := span.Conds
+		if len(conds) == 0 {
+			conds = []string{p.defaultConditionName()}
+		}
+
+		key := strings.Join(conds, "\x00")
+		b, ok := index[key]
+		if !ok {
+			b = &batch{conds: conds}
+			index[key] = b
+			batches = append(batches, b)
+		}
+		b.samples = append(b.samples, newSample(span.Start, span.End, false))
+	}
+
+	for _, b := range batches {
+		leaf, wait := p.lockLeaf(b.conds)
+		if leaf == nil {
+			continue
+		}
+
+		leaf.stats.registerSamples(b.samples)
+
+		leaf.Unlock()
+		leaf.stats.Unlock()
+		wait.record()
+
+		for _, s := range b.samples {
+			leaf.notify(s, b.conds)
+		}
+	}
+}
+
+// limitDepth returns conds rewritten so that no sub-profile is created below
+// p, which is at the maximum allowed depth (see [SetMaxProfileDepth]).
+// Composite profiles record such samples in their existing default condition
+// sub-profile, if any. Otherwise, if they have no sub-profile, they are made
+// non-composite to record them themselves, no sample being lost. It returns
+// false if the sample must be discarded, i.e., if p has sub-profiles but none
+// for the default condition.
+func (p *ProfileSt) limitDepth(conds []string) ([]string, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	defaultCond := p.defaultConditionName()
+	if !p.composite {
+		if len(conds) == 1 && conds[0] == defaultCond {
+			return conds, true
+		}
+	} else if _, ok := p.subProfiles[conds[0]]; ok {
+		return conds, true
+	}
+
+	if _, ok := p.subProfiles[defaultCond]; p.composite && !ok && len(p.subProfiles) > 0 {
+		getLogger().Error("maximum profile depth reached, sample discarded",
+			slog.String("profile", p.getFullName()),
+			slog.Any("conditions", conds))
+		return nil, false
+	}
+
+	getLogger().Error("maximum profile depth reached, recording sample at deepest allowed level",
+		slog.String("profile", p.getFullName()),
+		slog.Any("conditions", conds))
+	if p.composite && len(p.subProfiles) == 0 {
+		p.unsafeRemoveComposition()
+	}
+	return []string{defaultCond}, true
+}
+
+// unsafeRemoveComposition makes the composite profile p, which must be locked
+// and have no sub-profiles, non-composite.
+func (p *ProfileSt) unsafeRemoveComposition() {
+	// the statistics are replaced, as by MakeComposite
+	old := p.stats
+	old.Lock()
+	defer old.Unlock()
+
+	p.composite = false
+	p.subProfiles = nil
+	p.stats = newProfileStats(p)
+}
+
+// defaultConditionName returns the name of the condition used by p when none is
+// specified: the one set using [ProfileBuilder.WithDefaultCondition] if any,
+// otherwise the global one (see [SetDefaultConditionName]).
+func (p *ProfileSt) defaultConditionName() string {
+	if p.defaultCondition != "" {
+		return p.defaultCondition
+	}
+	return conf().defaultConditionName
+}
+
+// depth returns the depth of p, i.e., the number of profiles from the group to
+// p (included).
+func (p *ProfileSt) depth() int {
+	d := 1
+	for pp := p.parent; pp != nil; pp = pp.parent {
+		d++
+	}
+	return d
+}
+
+// SubProfileCount returns the number of sub-profiles of p, excluding their
+// descendants.
+func (p *ProfileSt) SubProfileCount() int {
+	p.RLock()
+	defer p.RUnlock()
+
+	return len(p.subProfiles)
+}
+
+// Depth returns the depth of the tree of sub-profiles rooted in p, i.e., the
+// number of levels of profiles below p: 0 if p has no sub-profiles, 1 if none
+// of them has sub-profiles, and so on. Unlike [SetMaxProfileDepth] it does not
+// account for the ancestors of p.
+func (p *ProfileSt) Depth() int {
+	p.RLock()
+	defer p.RUnlock()
+
+	d := 0
+	for _, sp := range p.subProfiles {
+		if spd := sp.Depth() + 1; spd > d {
+			d = spd
+		}
+	}
+	return d
+}
+
+// SetActive activates or deactivates profile p. While p is inactive, samples
+// recorded by p or by any of its descendants (including the ones created
+// afterwards) are discarded. Profiles are active by default.
+// Inactive profiles are marked as such by the Print functions.
+func (p *ProfileSt) SetActive(active bool) {
+	p.inactive.Store(!active)
+}
+
+// isActive returns false if p or any of its ancestors is inactive.
+func (p *ProfileSt) isActive() bool {
+	for ; p != nil; p = p.parent {
+		if p.inactive.Load() {
+			return false
+		}
+	}
+	return true
+}
+
+// SetDurationScale makes profile p, and any of its descendants, multiply by
+// factor the durations of the samples recorded afterwards, e.g., 0.5 to model
+// an operation twice as fast, so that hypothetical speedups can be explored
+// against real workloads. Scales of nested profiles multiply.
+// Scaled profiles are marked as such by the Print functions, and their scale
+// is reported by snapshots, exports and callbacks (see [ProfileSt.OnSample]),
+// since their statistics are not measured.
+// A factor of 1 removes the scale. An error is logged, and the scale is left
+// unchanged, if factor is not a positive finite number.
+func (p *ProfileSt) SetDurationScale(factor float64) {
+	if !(factor > 0) || math.IsInf(factor, 1) {
+		getLogger().Error("duration scale must be a positive finite number",
+			slog.String("profile", p.getFullName()),
+			slog.Float64("factor", factor))
+		return
+	}
+
+	if factor == 1 {
+		p.scale.Store(0)
+		return
+	}
+	p.scale.Store(math.Float64bits(factor))
+}
+
+// durationScale returns the product of the duration scales of p and of its
+// ancestors, 1 if none is scaled.
+func (p *ProfileSt) durationScale() float64 {
+	scale := 1.0
+	for ; p != nil; p = p.parent {
+		if bits := p.scale.Load(); bits != 0 {
+			scale *= math.Float64frombits(bits)
+		}
+	}
+	return scale
+}
+
+// displayName returns the full name of p, marked if p is inactive.
+func (p *ProfileSt) displayName() string {
+	return truncateName(p.getFullName(), p.name, conf().maxNameWidth) + p.flags()
+}
+
+// flags returns the annotations appended to the name of p in tables, e.g.,
+// " (inactive)". Statistics must be up to date.
+func (p *ProfileSt) flags() string {
+	var f string
+	if !p.isActive() {
+		f += " (inactive)"
+	}
+	if t := conf().idleThreshold; t > 0 && p.staleness() > t {
+		f += " (idle)"
+	}
+	if scale := p.durationScale(); scale != 1 {
+		f += fmt.Sprintf(" (scaled x%g)", scale)
+	}
+	return f
+}
+
+// OnSample registers fn to be called each time a sample is recorded by profile p
+// or by any of its descendants. fn receives the duration of the sample, scaled
+// if the profile recording it is (see [ProfileSt.SetDurationScale]), and the
+// conditions specified when stopping the timer (see [Timer.StopAs]).
+// Multiple callbacks can be registered, they are invoked in registration order
+// without holding any asten lock, hence fn may safely use p.
+func (p *ProfileSt) OnSample(fn func(d time.Duration, conds []string)) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.callbacks = append(p.callbacks, fn)
+}
+
+// notify invokes the callbacks registered on p and its ancestors for sample s
+// recorded with conditions conds. It must be called without holding any lock.
+func (p *ProfileSt) notify(s sample, conds []string) {
+	// the duration accounted for by the statistics
+	if scale := p.durationScale(); scale != 1 {
+		s = s.scaled(scale)
+	}
+	d := s.duration()
+
+	for ; p != nil; p = p.parent {
+		p.RLock()
+		callbacks := p.callbacks
+		p.RUnlock()
+
+		for _, fn := range callbacks {
+			fn(d, conds)
+		}
+	}
+}
+
+// path returns the names of the profiles from the top-level ancestor of p to
+// p (included).
+func (p *ProfileSt) path() []string {
+	names := []string{}
+	for ; p != nil; p = p.parent {
+		names = append([]string{p.name}, names...)
+	}
+	return names
+}
+
+func (p *ProfileSt) getFullName() string {
+	names := []string{p.name}
+
+	for p.parent != nil {
+		p = p.parent
+		names = append(names, p.name)
+	}
+
+	var b bytes.Buffer
+	for i := len(names) - 1; i > 0; i-- {
+		b.WriteString(names[i])
+		b.WriteString(" -> ")
+	}
+	b.WriteString(names[0])
+	return b.String()
+}
+
+// Summary returns a single-line description of profile p in the form:
+//
+//	name: mean=..., n=..., effective=...
+//
+// where name is the full name of p.
+func (p *ProfileSt) Summary() string {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	return fmt.Sprintf("%s: mean=%s, n=%d, effective=%s",
+		p.getFullName(),
+		time.Duration(p.stats.meanTime),
+		p.stats.count(),
+		p.stats.effectiveTime.duration())
+}
+
+// String returns a full dump of profile p unless [SetDefaultStringVerbose] has
+// been called with false, in which case it is equivalent to [ProfileSt.Summary].
+func (p *ProfileSt) String() string {
+	if !conf().verboseString {
+		return p.Summary()
+	}
+
+	b := bytes.NewBufferString("")
+
+	b.WriteString(fmt.Sprintf("[Profile %s]\n", p.name))
+	if p.parent != nil {
+		b.WriteString(fmt.Sprintf("parent: %s\n", p.parent.name))
+	}
+	if p.location != "" {
+		b.WriteString(fmt.Sprintf("location: %s\n", p.location))
+	}
+	if p.description != "" {
+		b.WriteString(fmt.Sprintf("description: %s\n", p.description))
+	}
+
+	s := indent(p.stats.String())
+	b.WriteString(s)
+
+	b.WriteString(fmt.Sprintf("memory: %t\n", p.memory))
+	b.WriteString(fmt.Sprintf("threads: %d\n", p.nThreads))
+	b.WriteString(fmt.Sprintf("default condition: %s\n", p.defaultConditionName()))
+	b.WriteString(fmt.Sprintf("composite: %t\n", p.composite))
+	if p.composite {
+		s := p.builder.String()
+		s = indent(s)
+		b.WriteString(fmt.Sprintf("builder: \n%s\n", s))
+		b.WriteString("sub profiles:\n")
+		for _, spName := range sortedKeys(p.subProfiles) {
+			s := indent(p.subProfiles[spName].String())
+			b.WriteString(s)
+		}
+	}
+
+	return b.String()
+}
+
+// Print generates and prints in a recursive manner tables containing info
+// about the profile p and its sub-profiles.
+func (p *ProfileSt) Print() {
+	p.recursiveLock()
+	cp := p.updateAndCopy()
+	p.recursiveUnlock()
+
+	cp.print(color.Output, defaultReportConfig())
+}
+
+// Fprint is equivalent to [ProfileSt.Print] but writes the tables to w.
+func (p *ProfileSt) Fprint(w io.Writer) {
+	p.recursiveLock()
+	cp := p.updateAndCopy()
+	p.recursiveUnlock()
+
+	cp.print(w, defaultReportConfig())
+}
+
+// Equivalent to Fprint but does not generate copy or updates
+func (cp *ProfileSt) print(w io.Writer, rc ReportConfig) {
+	headerFmt := color.New(color.FgYellow, color.Underline).SprintfFunc()
+
+	if !cp.composite {
+		cols := leafColumns(rc.Columns)
+		tbl := newTable(cols)
+		tbl.WithHeaderFormatter(headerFmt).WithWriter(w)
+		tbl.AddRow(sloColored(cp, row(cp, cols))...)
+
+		color.New(color.FgYellow).Add(color.Bold).Fprintf(w, "\n%s Profile %s\n", conf().glyphs.Profile, cp.title())
+		tbl.Print()
+		printEngineTable(w, headerFmt, map[string]*ProfileSt{cp.name: cp}, []string{cp.name})
+		if cp.description != "" {
+			fmt.Fprintf(w, "note: %s\n", cp.description)
+		}
+		return
+	}
+
+	columns := rc.Columns
+	tbl := newTable(columns)
+	tbl.WithHeaderFormatter(headerFmt).WithWriter(w)
+
+	displayed := rc.order(cp.subProfiles, cp.displayedSubProfiles())
+	if len(displayed) == 0 {
+		// only the collapsed default condition, displayed by the parent row
+		return
+	}
+	for _, spName := range displayed {
+		sp := cp.subProfiles[spName]
+		tbl.AddRow(sloColored(sp, row(sp, columns))...)
+	}
+	color.New(color.FgYellow).Add(color.Bold).Fprintf(w, "\n%s Profile %s\n", conf().glyphs.Profile, cp.title())
+	tbl.Print()
+	printEngineTable(w, headerFmt, cp.subProfiles, displayed)
+
+	for _, spName := range displayed {
+		if desc := cp.subProfiles[spName].description; desc != "" {
+			fmt.Fprintf(w, "note: %s: %s\n", spName, desc)
+		}
+	}
+	if d := cp.dominant(); d != nil {
+		fmt.Fprintf(w, "dominant: %s (%d%% of effective time)\n", d.name, int(d.stats.timeslice*100))
+	}
+
+	for _, spName := range displayed {
+		sp := cp.subProfiles[spName]
+		if sp.composite {
+			sp.print(w, rc)
+		}
+	}
+}
+
+// dominant returns the sub-profile of the composite profile p with the largest
+// timeslice, the first by name in case of ties. It returns nil if p has less
+// than two sub-profiles or no effective runtime. Statistics must be up to date.
+func (p *ProfileSt) dominant() *ProfileSt {
+	displayed := p.displayedSubProfiles()
+	if len(displayed) < 2 || p.stats.effectiveTime.isZero() {
+		return nil
+	}
+
+	var d *ProfileSt
+	for _, spName := range displayed {
+		sp := p.subProfiles[spName]
+		if d == nil || sp.stats.timeslice > d.stats.timeslice {
+			d = sp
+		}
+	}
+	return d
+}
+
+// displayedSubProfiles returns the names of the sub-profiles of p displayed by
+// the Print functions and included in snapshots, sorted, i.e., all of them
+// unless the default condition is collapsed (see
+// [SetCollapseDefaultCondition]).
+func (p *ProfileSt) displayedSubProfiles() []string {
+	names := sortedKeys(p.subProfiles)
+	if !conf().collapseDefault {
+		return names
+	}
+
+	defaultCond := p.defaultConditionName()
+	if sp, ok := p.subProfiles[defaultCond]; !ok || sp.composite {
+		return names
+	}
+	i := slices.Index(names, defaultCond)
+	return slices.Delete(names, i, i+1)
+}
+
+// SetDescription sets the human-readable description of profile p, displayed
+// by String, in the snapshots of p and as a note below the tables printed by
+// the Print functions (see also [ProfileBuilder.WithDescription]).
+func (p *ProfileSt) SetDescription(s string) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.description = s
+}
+
+// Samples returns a copy of the durations of the samples recorded by profile p.
+// Only memory full, non-composite profiles retain samples: nil is returned
+// for any other profile.
+func (p *ProfileSt) Samples() []time.Duration {
+	p.RLock()
+	defer p.RUnlock()
+	p.stats.RLock()
+	defer p.stats.RUnlock()
+
+	if p.composite || !p.memory {
+		return nil
+	}
+
+	ds := make([]time.Duration, len(p.stats.samples))
+	for i, s := range p.stats.samples {
+		ds[i] = time.Duration(s.getDurationNano())
+	}
+	return ds
+}
+
+// # Span
+//
+// Represents the time interval covered by a sample and, optionally, the
+// conditions under which it is recorded (see [ProfileSt.RecordBatch]).
+type Span struct {
+	Start time.Time
+	End   time.Time
+	Conds []string
+}
+
+// SampleSpans is equivalent to [ProfileSt.Samples] but returns the start and
+// end time of each sample instead of its duration.
+func (p *ProfileSt) SampleSpans() []Span {
+	p.RLock()
+	defer p.RUnlock()
+	p.stats.RLock()
+	defer p.stats.RUnlock()
+
+	if p.composite || !p.memory {
+		return nil
+	}
+
+	spans := make([]Span, len(p.stats.samples))
+	for i, s := range p.stats.samples {
+		spans[i] = Span{Start: s.start, End: s.end}
+	}
+	return spans
+}
+
+// ExclusiveTime returns the effective runtime attributed directly to profile p,
+// as opposed to its effective runtime (inclusive time) which, for composite
+// profiles, is the sum of the ones of its sub-profiles.
+// Samples recorded on a composite profile without specifying a condition are
+// registered in its default condition sub-profile (see [Timer.Stop]): the
+// exclusive time of a composite profile is the effective runtime of such
+// sub-profile, or 0 if it does not exist. For non-composite profiles exclusive
+// and inclusive time coincide.
+func (p *ProfileSt) ExclusiveTime() time.Duration {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	if !p.composite {
+		return p.stats.effectiveTime.duration()
+	}
+
+	sp, ok := p.subProfiles[p.defaultConditionName()]
+	if !ok {
+		return 0
+	}
+	return sp.stats.effectiveTime.duration()
+}
+
+// MeanAlloc returns the mean number of bytes allocated on the heap per sample
+// recorded by profile p (or by its descendants if p is composite) using
+// [Timer.StopWithAlloc]. Samples recorded without tracking allocations count
+// as zero bytes.
+func (p *ProfileSt) MeanAlloc() uint64 {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	return p.stats.meanAlloc()
+}
+
+// MeanCPUTime returns the mean CPU time per sample recorded by profile p (or by
+// its descendants if p is composite), see [ProfileBuilder.WithCPUTime].
+// Samples recorded without measuring the CPU time count as zero. Compared to
+// the mean runtime it reveals the time spent blocked rather than computing.
+func (p *ProfileSt) MeanCPUTime() time.Duration {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	return time.Duration(p.stats.meanCPU())
+}
+
+// LastSampleAt returns the end time of the most recent sample recorded by
+// profile p or by its descendants, the zero time if none.
+func (p *ProfileSt) LastSampleAt() time.Time {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	return p.stats.lastSample
+}
+
+// Staleness returns the time elapsed since the end of the most recent sample
+// recorded by profile p or by its descendants (see [ProfileSt.LastSampleAt]),
+// 0 if none. A long staleness reveals a code path not exercised anymore.
+func (p *ProfileSt) Staleness() time.Duration {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	return p.staleness()
+}
+
+// staleness is equivalent to Staleness, statistics must be up to date.
+func (p *ProfileSt) staleness() time.Duration {
+	if p.stats.lastSample.IsZero() {
+		return 0
+	}
+	return conf().clock.Now().Sub(p.stats.lastSample)
+}
+
+// ErrorRate returns the fraction of the samples recorded by profile p (or by
+// its descendants if p is composite) that were marked as failed (see
+// [Timer.StopErr]).
+func (p *ProfileSt) ErrorRate() float64 {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	return p.stats.errorRate()
+}
+
+// SetAggregate replaces the samples and the statistics of the non-composite
+// profile p with the given total runtime, effective runtime and number of
+// samples, bypassing the recording of individual samples, e.g., to import
+// statistics aggregated by another system or to test reports. Samples
+// recorded afterwards are added to such statistics. Percentiles are not
+// available for the given samples.
+// An error is logged, and p is not modified, if p is composite, its
+// statistics being computed from its sub-profiles, or if a runtime is
+// negative.
+func (p *ProfileSt) SetAggregate(total, effective time.Duration, n uint64) {
+	if total < 0 || effective < 0 {
+		getLogger().Error("invalid aggregate, runtimes must be >= 0",
+			slog.String("profile", p.getFullName()),
+			slog.Duration("total", total),
+			slog.Duration("effective", effective))
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.composite {
+		getLogger().Error("aggregate statistics cannot be set for composite profiles",
+			slog.String("profile", p.getFullName()))
+		return
+	}
+
+	p.stats.Lock()
+	defer p.stats.Unlock()
+
+	p.stats.setAggregate(uint64(total), uint64(effective), n)
+}
+
+// StdDev returns the standard deviation of the runtimes of the samples recorded
+// by profile p, or by its descendants if p is composite, for both memory full
+// and memoryless profiles. Samples whose statistics have been set using
+// [ProfileSt.SetAggregate] are not accounted for.
+// The runtimes are the durations of the samples, not divided by the number of
+// threads: for multi-threaded profiles they differ from the mean runtime.
+func (p *ProfileSt) StdDev() time.Duration {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	return time.Duration(p.stats.stdDev())
+}
+
+// StdErr returns the standard error of the mean of the runtimes of the samples
+// recorded by profile p, i.e., its standard deviation (see [ProfileSt.StdDev])
+// divided by the square root of the number of samples. It quantifies the
+// uncertainty of the mean runtime, e.g., to establish whether the difference
+// between two runs is within noise (see also [CompareProfiles]).
+func (p *ProfileSt) StdErr() time.Duration {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	return time.Duration(p.stats.stdErr())
+}
+
+// ConditionRate returns the fraction of the samples recorded by profile p that
+// were recorded in the sub-profile at the path conds, as created by
+// [Timer.StopAs], e.g., the fraction of calls that timed out:
+//
+//	p.ConditionRate("timeout")
+//
+// For direct sub-profiles it is the branch taken displayed by the Print
+// functions. 0 is returned if no sub-profile exists at the given path or if p
+// has no samples.
+func (p *ProfileSt) ConditionRate(conds ...string) float64 {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	target := p
+	for _, cond := range conds {
+		sp, ok := target.subProfiles[cond]
+		if !ok {
+			return 0
+		}
+		target = sp
+	}
+
+	return ratio(target.stats.count(), p.stats.count())
+}
+
+// HasConditionPath returns whether profile p has a sub-profile at the path
+// conds, as created by [Timer.StopAs], which has recorded samples. Unlike
+// StopAs it never creates sub-profiles, e.g., to check that an error path has
+// been exercised:
+//
+//	if !p.HasConditionPath("status=500") {
+//		t.Error("error path not exercised")
+//	}
+func (p *ProfileSt) HasConditionPath(conds ...string) bool {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	target := p
+	for _, cond := range conds {
+		sp, ok := target.subProfiles[cond]
+		if !ok {
+			return false
+		}
+		target = sp
+	}
 
-	return p
+	return target.stats.count() > 0
 }
 
-// Builder returns a pointer to the builder used to generate new sub-profiles
-// for profile p.
-func (p *ProfileSt) Builder() *ProfileBuilder {
-	p.RLock()
-	if p.composite {
-		defer p.RUnlock()
-		return p.builder
-	}
-	p.Unlock()
-
+// GCAffectedSamples returns the number of samples recorded by profile p, or by
+// its descendants if p is composite, during which at least one garbage
+// collection cycle completed (see [ProfileBuilder.WithGCAttribution]).
+// Comparing it with the number of samples helps telling slow code apart from
+// latency induced by the GC. Samples recorded without attributing GCs are not
+// counted.
+func (p *ProfileSt) GCAffectedSamples() uint64 {
 	p.recursiveLock()
 	defer p.recursiveUnlock()
+	p.update()
 
-	logger.Warn(
-		"requested builder of non composite profile. Profile will be made composite, all previous samples will be lost",
-		slog.String("profile", p.getFullName()),
-	)
-	p.unsafeMakeComposite()
-	return p.builder
+	return p.stats.gcAffected
 }
 
-// SetBuilder sets the builder used by profile p to generate new profiles to pb.
-func (p *ProfileSt) SetBuilder(pb *ProfileBuilder) {
-	p.Lock()
-	defer p.Unlock()
+// maxSeriesBuckets is the maximum number of buckets returned by Series
+const maxSeriesBuckets = 100000
 
-	pb.parentProfile = p
-	p.builder = pb
+// # SeriesPoint
+//
+// Contains the statistics of the samples whose end time falls within the
+// bucket starting at Start (see [ProfileSt.Series]).
+type SeriesPoint struct {
+	Start time.Time
+	Count uint64
+	Mean  time.Duration
 }
 
-// StartTimer starts and returns a [Timer] relative to profile p.
-func (p *ProfileSt) StartTimer() *Timer {
-	return &Timer{
-		profile: p,
-		start:   time.Now(),
+// Series groups the samples recorded by profile p into consecutive buckets of
+// width bucket, based on their end time, and returns the number of samples
+// and their mean duration for each bucket, in chronological order.
+// Buckets are aligned to multiples of bucket since the zero time (see
+// [time.Time.Truncate]); empty buckets between the first and last sample are
+// included.
+// Only memory full, non-composite profiles retain samples: nil is returned
+// for any other profile. An error is logged, and nil returned, if more than
+// 100000 buckets would be needed, e.g., because of a bucket too narrow for the
+// time spanned by the samples.
+func (p *ProfileSt) Series(bucket time.Duration) []SeriesPoint {
+	if bucket <= 0 {
+		getLogger().Error("invalid bucket width, must be > 0",
+			slog.Duration("bucket", bucket))
+		return nil
 	}
-}
 
-func (p *ProfileSt) registerTimer(t *Timer) {
-	if len(t.conds) > 1 {
-		cond := t.conds[0]
-		t.conds = t.conds[1:]
-		p.Profile(cond).registerTimer(t)
-		return
+	spans := p.SampleSpans()
+	if len(spans) == 0 {
+		return nil
 	}
 
-	p.Lock()
-
-	cond := t.conds[0]
-	if !p.composite {
-		// if profile is not composite but a condition is specified then the
-		// profile is made composite and the timer is passed to a new subprofile
-		if cond != default_condition_name {
-
-			logger.Warn("making profile composite, previous samples will be lost",
-				slog.String("profile", p.getFullName()))
-			p.unsafeMakeComposite()
-
-			t.conds = []string{default_condition_name}
-			p.Unlock()
+	first, last := spans[0].End, spans[0].End
+	for _, s := range spans {
+		if s.End.Before(first) {
+			first = s.End
+		}
+		if s.End.After(last) {
+			last = s.End
+		}
+	}
+	first = first.Truncate(bucket)
+
+	// Sub saturates, hence spans too long to be represented are rejected too
+	n := last.Sub(first) / bucket
+	if n >= maxSeriesBuckets {
+		getLogger().Error("too many buckets, use a wider bucket",
+			slog.String("profile", p.getFullName()),
+			slog.Duration("bucket", bucket),
+			slog.Duration("span", last.Sub(first)))
+		return nil
+	}
 
-			p.Profile(cond).registerTimer(t)
-			return
+	points := make([]SeriesPoint, n+1)
+	totals := make([]time.Duration, len(points))
+	for i := range points {
+		points[i].Start = first.Add(time.Duration(i) * bucket)
+	}
+	for _, s := range spans {
+		i := s.End.Sub(first) / bucket
+		points[i].Count++
+		totals[i] += s.End.Sub(s.Start)
+	}
+	for i := range points {
+		if points[i].Count > 0 {
+			points[i].Mean = totals[i] / time.Duration(points[i].Count)
 		}
+	}
 
-		p.stats.Lock()
-		p.stats.registerSample(newSample(t.start, t.end))
+	return points
+}
 
-		p.Unlock()
-		p.stats.Unlock()
-		return
+// Percentile returns the q-th quantile (0 <= q <= 1) of the runtimes recorded
+// by profile p, computed using the nearest-rank method.
+// Percentiles can only be computed exactly for memory full profiles: if p (or,
+// for composite profiles, any of its descendants) is memoryless, 0 is returned
+// unless p is non-composite and estimates q (see
+// [ProfileBuilder.WithApproxPercentiles]), in which case the estimate is
+// returned.
+func (p *ProfileSt) Percentile(q float64) time.Duration {
+	if q < 0 || q > 1 {
+		getLogger().Error("invalid quantile, must be in [0, 1]",
+			slog.Float64("q", q))
+		return 0
 	}
-	p.Unlock()
 
-	t.conds = []string{default_condition_name}
+	p.recursiveRLock()
+	defer p.recursiveRUnlock()
 
-	p.Profile(cond).registerTimer(t)
+	d, ok := p.percentile(q)
+	if !ok && p.hasCompacted() {
+		getLogger().Warn("samples have been discarded by CompactSamples, percentile not available",
+			slog.String("profile", p.getFullName()))
+	}
+	return d
 }
 
-func (p *ProfileSt) getFullName() string {
-	names := []string{p.name}
-
-	for p.parent != nil {
-		p = p.parent
-		names = append(names, p.name)
+// PercentileWithConfidence is like [ProfileSt.Percentile], but the returned
+// boolean is false if the quantile cannot be estimated reliably, i.e., if it is
+// not available or p has fewer than 1/(1-q) samples, e.g., 1000 for the 99.9th
+// percentile.
+func (p *ProfileSt) PercentileWithConfidence(q float64) (time.Duration, bool) {
+	if q < 0 || q > 1 {
+		getLogger().Error("invalid quantile, must be in [0, 1]",
+			slog.Float64("q", q))
+		return 0, false
 	}
 
-	var b bytes.Buffer
-	for i := len(names) - 1; i > 0; i-- {
-		b.WriteString(names[i])
-		b.WriteString(" -> ")
-	}
-	b.WriteString(names[0])
-	return b.String()
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	d, ok := p.percentile(q)
+	return d, ok && p.enoughSamples(q)
 }
 
-func (p *ProfileSt) String() string {
-	b := bytes.NewBufferString("")
+// enoughSamples returns whether the (already updated) profile p has enough
+// samples to estimate quantile q reliably.
+func (p *ProfileSt) enoughSamples(q float64) bool {
+	// tolerate rounding errors, e.g., 10*(1-0.9) < 1
+	return float64(p.stats.nsamples)*(1-q) >= 1-1e-9
+}
 
-	b.WriteString(fmt.Sprintf("[Profile %s]\n", p.name))
-	if p.parent != nil {
-		b.WriteString(fmt.Sprintf("parent: %s\n", p.parent.name))
-	}
+// CompactSamples discards the samples retained by profile p, or by its
+// non-composite descendants if p is composite, preserving the statistics
+// computed so far (runtimes and number of samples). Compacted profiles become
+// memoryless: the following samples update their statistics without being
+// retained. Exact percentiles are no longer available afterwards.
+// It allows reclaiming the memory of long running memory full profiles.
+func (p *ProfileSt) CompactSamples() {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
 
-	s := strings.Replace("\t"+p.stats.String(), "\n", "\n\t", -1)
-	s = s[:len(s)-1]
-	b.WriteString(s)
+	p.forEachLeaf(func(leaf *ProfileSt) {
+		if !leaf.memory {
+			return
+		}
+		leaf.memory = false
+		leaf.compacted = true
+		leaf.stats.release()
+		leaf.stats.samples = nil
+		leaf.stats.carry()
+	})
+}
 
-	b.WriteString(fmt.Sprintf("memory: %t\n", p.memory))
-	b.WriteString(fmt.Sprintf("threads: %d\n", p.nThreads))
-	b.WriteString(fmt.Sprintf("composite: %t\n", p.composite))
-	if p.composite {
-		s := p.builder.String()
-		s = strings.Replace("\t"+s, "\n", "\n\t", -1)
-		s = s[:len(s)-1]
-		b.WriteString(fmt.Sprintf("builder: \n%s\n", s))
-		b.WriteString("sub profiles:\n")
-		for _, subp := range p.subProfiles {
-			s := strings.Replace("\t"+subp.String(), "\n", "\n\t", -1)
-			s = s[:len(s)-1]
-			b.WriteString(s)
+// ResetCondition discards the samples recorded by the sub-profile of p at the
+// path conds, as created by [Timer.StopAs], or by its descendants if it is
+// composite, e.g., to check whether a fix holds for a specific error path:
+//
+//	p.ResetCondition("status=500")
+//
+// The statistics of p and of the other sub-profiles are recomputed
+// accordingly, while the samples of sibling conditions are preserved. If no
+// condition is specified the whole profile p is reset. An error is logged if
+// no sub-profile exists at the given path.
+func (p *ProfileSt) ResetCondition(conds ...string) {
+	target := p
+	for _, cond := range conds {
+		target.RLock()
+		sp, ok := target.subProfiles[cond]
+		target.RUnlock()
+
+		if !ok {
+			getLogger().Error("no sub-profile for the given conditions, nothing reset",
+				slog.String("profile", p.getFullName()),
+				slog.Any("conds", conds))
+			return
 		}
+		target = sp
 	}
 
-	return b.String()
+	target.recursiveLock()
+	defer target.recursiveUnlock()
+
+	target.forEachLeaf(func(leaf *ProfileSt) {
+		leaf.stats.reset()
+	})
 }
 
-// Print generates and prints in a recursive manner tables containing info
-// about the profile p and its sub-profiles.
-func (p *ProfileSt) Print() {
-	p.recursiveLock()
-	cp := p.updateAndCopy()
-	p.recursiveUnlock()
+// hasCompacted returns whether p or any of its descendants has been compacted
+// (see [ProfileSt.CompactSamples]).
+func (p *ProfileSt) hasCompacted() bool {
+	compacted := false
+	p.forEachLeaf(func(leaf *ProfileSt) {
+		compacted = compacted || leaf.compacted
+	})
+	return compacted
+}
 
-	headerFmt := color.New(color.FgYellow, color.Underline).SprintfFunc()
+func (p *ProfileSt) percentile(q float64) (time.Duration, bool) {
+	if !p.composite && !p.memory {
+		return p.stats.approxPercentile(q)
+	}
 
-	if !cp.composite {
-		tbl := table.New(
-			"profile",
-			"total runtime",
-			"effective runtime",
-			"mean runtime",
-			"branch taken",
-			"nsamples",
-		)
-		tbl.WithHeaderFormatter(headerFmt)
-		tbl.AddRow(cp.getFullName(),
-			time.Duration(cp.stats.totalTime),
-			time.Duration(cp.stats.effectiveTime),
-			time.Duration(cp.stats.meanTime),
-			cp.stats.taken,
-			cp.stats.nsamples)
-
-		color.New(color.FgYellow).Add(color.Bold).Printf("\n\u24c5 Profile %s\n", cp.name)
-		tbl.Print()
-		return
+	ds, ok := p.durations(nil)
+	if !ok || len(ds) == 0 {
+		return 0, false
 	}
+	return nearestRank(ds, q), true
+}
 
-	tbl := table.New(
-		"profile",
-		"timeslice",
-		"total runtime",
-		"effective runtime",
-		"mean runtime",
-		"branch taken",
-		"nsamples",
-	)
-	tbl.WithHeaderFormatter(headerFmt)
+// RecentPercentile is like [ProfileSt.Percentile], but the quantile is
+// computed over the window most recent samples of p only, ordered by end time,
+// e.g., the p95 of the last 100 requests for a live latency panel. It requires
+// p (or, for composite profiles, all of its descendants) to be memory full, 0
+// is returned otherwise.
+func (p *ProfileSt) RecentPercentile(window uint64, q float64) time.Duration {
+	if q < 0 || q > 1 {
+		getLogger().Error("invalid quantile, must be in [0, 1]",
+			slog.Float64("q", q))
+		return 0
+	}
+	if window == 0 {
+		getLogger().Error("window must be > 0")
+		return 0
+	}
 
-	for spName := range cp.subProfiles {
-		sp := cp.subProfiles[spName]
-		tbl.AddRow(sp.getFullName(),
-			math.Floor(sp.stats.timeslice*1000)/1000,
-			time.Duration(sp.stats.totalTime),
-			time.Duration(sp.stats.effectiveTime),
-			time.Duration(sp.stats.meanTime),
-			sp.stats.taken,
-			sp.stats.nsamples)
-	}
-	color.New(color.FgYellow).Add(color.Bold).Printf("\n\u24c5 Profile %s\n", cp.name)
-	tbl.Print()
+	p.recursiveRLock()
+	ss, ok := p.allSamples(nil)
+	p.recursiveRUnlock()
 
-	for spName := range cp.subProfiles {
-		sp := cp.subProfiles[spName]
-		if sp.composite {
-			sp.print()
-		}
+	if !ok || len(ss) == 0 {
+		return 0
 	}
-}
 
-// Equivalent to Print but does not generate copy or updates
-func (cp *ProfileSt) print() {
-	headerFmt := color.New(color.FgYellow, color.Underline).SprintfFunc()
+	slices.SortStableFunc(ss, func(a, b sample) bool {
+		return a.end.Before(b.end)
+	})
+	if uint64(len(ss)) > window {
+		ss = ss[uint64(len(ss))-window:]
+	}
 
-	if !cp.composite {
-		tbl := table.New(
-			"profile",
-			"total runtime",
-			"effective runtime",
-			"mean runtime",
-			"branch taken",
-			"nsamples",
-		)
-		tbl.WithHeaderFormatter(headerFmt)
-		tbl.AddRow(cp.getFullName(),
-			time.Duration(cp.stats.totalTime),
-			time.Duration(cp.stats.effectiveTime),
-			time.Duration(cp.stats.meanTime),
-			cp.stats.taken,
-			cp.stats.nsamples)
-
-		color.New(color.FgYellow).Add(color.Bold).Printf("\n\u24c5 Profile %s\n", cp.name)
-		tbl.Print()
-		return
+	ds := make([]uint64, len(ss))
+	for i, s := range ss {
+		ds[i] = s.getDurationNano()
 	}
+	return nearestRank(ds, q)
+}
 
-	tbl := table.New(
-		"profile",
-		"timeslice",
-		"total runtime",
-		"effective runtime",
-		"mean runtime",
-		"branch taken",
-		"nsamples",
-	)
-	tbl.WithHeaderFormatter(headerFmt)
+// nearestRank returns the q-th quantile of the non-empty ds, which is sorted
+// in place, computed using the nearest-rank method.
+func nearestRank(ds []uint64, q float64) time.Duration {
+	slices.Sort(ds)
 
-	for spName := range cp.subProfiles {
-		sp := cp.subProfiles[spName]
-		tbl.AddRow(sp.getFullName(),
-			math.Floor(sp.stats.timeslice*1000)/1000,
-			time.Duration(sp.stats.totalTime),
-			time.Duration(sp.stats.effectiveTime),
-			time.Duration(sp.stats.meanTime),
-			sp.stats.taken,
-			sp.stats.nsamples)
-	}
-	color.New(color.FgYellow).Add(color.Bold).Printf("\n\u24c5 Profile %s\n", cp.name)
-	tbl.Print()
+	i := int(math.Ceil(q*float64(len(ds)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return time.Duration(ds[i])
+}
 
-	for spName := range cp.subProfiles {
-		sp := cp.subProfiles[spName]
-		if sp.composite {
-			sp.print()
+// durations appends to ds the durations of the samples retained by p and its
+// descendants. The returned boolean is false if any of them is memoryless.
+func (p *ProfileSt) durations(ds []uint64) ([]uint64, bool) {
+	if !p.composite {
+		if !p.memory {
+			return ds, false
+		}
+		for _, s := range p.stats.samples {
+			ds = append(ds, s.getDurationNano())
+		}
+		return ds, true
+	}
+
+	for _, sp := range p.subProfiles {
+		var ok bool
+		if ds, ok = sp.durations(ds); !ok {
+			return ds, false
 		}
 	}
+	return ds, true
+}
+
+// title returns the name of p as displayed in table titles.
+func (p *ProfileSt) title() string {
+	t := p.name
+	if p.location != "" {
+		t += " [" + shortLocation(p.location) + "]"
+	}
+	return t + p.flags()
 }
 
 func (p *ProfileSt) copy() *ProfileSt {
 	cp := &ProfileSt{
 		RWMutex: &sync.RWMutex{},
 		name:    p.name,
+		id:      profileIDs.Add(1),
 
 		composite: p.composite,
 		builder:   p.builder.Copy(),
 		nThreads:  p.nThreads,
 		memory:    p.memory,
 		stats:     p.stats.copy(),
+		baseline:  p.baseline,
+
+		defaultCondition: p.defaultCondition,
+		approxQuantiles:  p.approxQuantiles,
+		warmup:           p.warmup,
+		trackAlloc:       p.trackAlloc,
+		timerTimeout:     p.timerTimeout,
+		callerInfo:       p.callerInfo,
+		parallelismFloor: p.parallelismFloor,
+		decayHalfLife:    p.decayHalfLife,
+		compacted:        p.compacted,
+		memoryThreshold:  p.memoryThreshold,
+		description:      p.description,
+		gcAttribution:    p.gcAttribution,
+		rollup:           p.rollup,
+		cpuTime:          p.cpuTime,
+		statsEngine:      p.statsEngine,
+		sloTarget:        p.sloTarget,
+		sloQuantile:      p.sloQuantile,
+		location:         p.location,
 	}
 
+	cp.inactive.Store(p.inactive.Load())
+	cp.scale.Store(p.scale.Load())
 	cp.stats.profile = cp
+	// the copy must not generate profiles into the original tree
+	cp.builder.WithParentProfile(cp)
 
 	if !p.composite {
 		return cp
@@ -385,42 +1453,87 @@ func (p *ProfileSt) copy() *ProfileSt {
 	return cp
 }
 
+// profileIDs generates the identifiers of the profiles.
+var profileIDs atomic.Uint64
+
+// lockKey is the position of a profile in the order in which profiles are
+// locked: the identifier of the root of its tree followed by the names of the
+// profiles from the root (excluded) to it, see lockKey.less.
+type lockKey struct {
+	root  uint64
+	names []string
+}
+
+// lockKey returns the position of p in the lock order. The parent of a profile
+// never changes, hence neither does its key.
+func (p *ProfileSt) lockKey() lockKey {
+	var names []string
+	for ; p.parent != nil; p = p.parent {
+		names = append([]string{p.name}, names...)
+	}
+	return lockKey{root: p.id, names: names}
+}
+
+// less returns whether the profile at k is locked before the one at o, i.e.,
+// trees are ordered by identifier of their root and, within a tree, profiles
+// precede their sub-profiles, which are ordered by name.
+func (k lockKey) less(o lockKey) bool {
+	if k.root != o.root {
+		return k.root < o.root
+	}
+	for i := 0; i < len(k.names) && i < len(o.names); i++ {
+		if k.names[i] != o.names[i] {
+			return k.names[i] < o.names[i]
+		}
+	}
+	return len(k.names) < len(o.names)
+}
+
+// contains returns whether the profile at o is the one at k or one of its
+// descendants.
+func (k lockKey) contains(o lockKey) bool {
+	return k.root == o.root && len(k.names) <= len(o.names) &&
+		slices.Equal(k.names, o.names[:len(k.names)])
+}
+
+// recursiveLock locks p, its descendants and their statistics. Each profile is
+// locked before its sub-profiles, which are locked in order of name, i.e., in
+// the order of their lock keys, so that goroutines locking overlapping trees
+// cannot deadlock. Recorders hold at most the lock of a profile and of its
+// statistics at a time, see lockLeaf.
 func (p *ProfileSt) recursiveLock() {
 	p.Lock()
 	p.stats.Lock()
 
-	for spName := range p.subProfiles {
-		p.subProfiles[spName].Lock()
-		p.subProfiles[spName].stats.Lock()
+	for _, spName := range sortedKeys(p.subProfiles) {
+		p.subProfiles[spName].recursiveLock()
 	}
 }
 
 func (p *ProfileSt) recursiveUnlock() {
 	for spName := range p.subProfiles {
-		p.subProfiles[spName].Unlock()
-		p.subProfiles[spName].stats.Unlock()
+		p.subProfiles[spName].recursiveUnlock()
 	}
-	p.Unlock()
 	p.stats.Unlock()
+	p.Unlock()
 }
 
+// recursiveRLock is equivalent to recursiveLock but acquires read locks.
 func (p *ProfileSt) recursiveRLock() {
 	p.RLock()
 	p.stats.RLock()
 
-	for spName := range p.subProfiles {
-		p.subProfiles[spName].RLock()
-		p.subProfiles[spName].stats.RLock()
+	for _, spName := range sortedKeys(p.subProfiles) {
+		p.subProfiles[spName].recursiveRLock()
 	}
 }
 
 func (p *ProfileSt) recursiveRUnlock() {
 	for spName := range p.subProfiles {
-		p.subProfiles[spName].RUnlock()
-		p.subProfiles[spName].stats.RUnlock()
+		p.subProfiles[spName].recursiveRUnlock()
 	}
-	p.RUnlock()
 	p.stats.RUnlock()
+	p.RUnlock()
 }
 
 func (p *ProfileSt) update() {
@@ -431,6 +1544,15 @@ func (p *ProfileSt) update() {
 	p.stats.update()
 }
 
+// Flush recomputes the statistics of profile p and of its descendants, so that
+// they are valid and current, without printing anything.
+func (p *ProfileSt) Flush() {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+
+	p.update()
+}
+
 func (p *ProfileSt) updateAndCopy() *ProfileSt {
 	p.update()
 	cp := p.copy()
@@ -448,11 +1570,25 @@ func (p *ProfileSt) updateAndCopy() *ProfileSt {
 // Its zero value has no particular meaning and should not be used.
 // A ProfileBuilder should always be instantiated using [NewProfileBuilder].
 type ProfileBuilder struct {
-	parentGroup   *GroupSt
-	parentProfile *ProfileSt
-	composite     bool
-	nThreads      uint64
-	memory        bool
+	parentGroup      *GroupSt
+	parentProfile    *ProfileSt
+	composite        bool
+	nThreads         uint64
+	memory           bool
+	defaultCondition string
+	approxQuantiles  []float64
+	warmup           uint64
+	trackAlloc       bool
+	timerTimeout     time.Duration
+	callerInfo       bool
+	parallelismFloor uint64
+	decayHalfLife    time.Duration
+	memoryThreshold  time.Duration
+	description      string
+	gcAttribution    bool
+	rollup           RollupFunc
+	cpuTime          bool
+	statsEngine      StatsEngine
 }
 
 func (pb ProfileBuilder) String() string {
@@ -471,6 +1607,38 @@ func (pb ProfileBuilder) String() string {
 	b.WriteString(fmt.Sprintf("composite: %t\n", pb.composite))
 	b.WriteString(fmt.Sprintf("memory: %t\n", pb.memory))
 	b.WriteString(fmt.Sprintf("threads: %d\n", pb.nThreads))
+	b.WriteString(fmt.Sprintf("default condition: %s\n", pb.defaultConditionName()))
+	b.WriteString(fmt.Sprintf("approximate percentiles: %v\n", pb.approxQuantiles))
+	b.WriteString(fmt.Sprintf("warmup: %d\n", pb.warmup))
+	b.WriteString(fmt.Sprintf("allocation tracking: %t\n", pb.trackAlloc))
+	if pb.timerTimeout > 0 {
+		b.WriteString(fmt.Sprintf("timer timeout: %v\n", pb.timerTimeout))
+	}
+	b.WriteString(fmt.Sprintf("caller info: %t\n", pb.callerInfo))
+	if pb.parallelismFloor > 0 {
+		b.WriteString(fmt.Sprintf("parallelism floor: %d\n", pb.parallelismFloor))
+	}
+	if pb.decayHalfLife > 0 {
+		b.WriteString(fmt.Sprintf("decay half-life: %v\n", pb.decayHalfLife))
+	}
+	if pb.memoryThreshold > 0 {
+		b.WriteString(fmt.Sprintf("memory if slower than: %v\n", pb.memoryThreshold))
+	}
+	if pb.description != "" {
+		b.WriteString(fmt.Sprintf("description: %s\n", pb.description))
+	}
+	if pb.gcAttribution {
+		b.WriteString("gc attribution: true\n")
+	}
+	if pb.rollup != nil {
+		b.WriteString("custom rollup: true\n")
+	}
+	if pb.cpuTime {
+		b.WriteString("cpu time: true\n")
+	}
+	if pb.statsEngine != nil {
+		b.WriteString(fmt.Sprintf("stats engine: %T\n", pb.statsEngine))
+	}
 
 	return b.String()
 }
@@ -497,13 +1665,50 @@ func NewProfileBuilder() *ProfileBuilder {
 // The generated profile will have a builder with the same characteristics as
 // pb except it will always be non-composite.
 func (pb *ProfileBuilder) NewProfile(pname string) *ProfileSt {
+	p := pb.newProfile(pname)
+
+	// assign o to profile or group
+	if pb.parentProfile != nil {
+		pb.parentProfile.Lock()
+		p = pb.parentProfile.addProfile(p)
+		pb.parentProfile.Unlock()
+	} else if pb.parentGroup != nil {
+		pb.parentGroup.addProfile(p)
+	}
+
+	return p
+}
+
+// newProfile is equivalent to NewProfile but the generated profile is not
+// added to the parent profile or group of pb.
+func (pb *ProfileBuilder) newProfile(pname string) *ProfileSt {
 	p := &ProfileSt{
 		RWMutex:   &sync.RWMutex{},
 		name:      pname,
+		id:        profileIDs.Add(1),
 		parent:    pb.parentProfile,
 		composite: pb.composite,
 		memory:    pb.memory,
 		nThreads:  pb.nThreads,
+
+		defaultCondition: pb.defaultCondition,
+		approxQuantiles:  pb.approxQuantiles,
+		warmup:           pb.warmup,
+		trackAlloc:       pb.trackAlloc,
+		timerTimeout:     pb.timerTimeout,
+		callerInfo:       pb.callerInfo,
+		parallelismFloor: pb.parallelismFloor,
+		decayHalfLife:    pb.decayHalfLife,
+		memoryThreshold:  pb.memoryThreshold,
+		description:      pb.description,
+		gcAttribution:    pb.gcAttribution,
+		rollup:           pb.rollup,
+		cpuTime:          pb.cpuTime,
+		statsEngine:      pb.statsEngine,
+	}
+
+	if p.callerInfo {
+		p.location = callerLocation()
 	}
 
 	p.builder = pb.Copy().RemoveComposition().WithParentProfile(p)
@@ -514,15 +1719,6 @@ func (pb *ProfileBuilder) NewProfile(pname string) *ProfileSt {
 
 	p.stats = newProfileStats(p)
 
-	// assign o to profile or group
-	if pb.parentProfile != nil {
-		pb.parentProfile.Lock()
-		p = pb.parentProfile.addProfile(p)
-		pb.parentProfile.Unlock()
-	} else if pb.parentGroup != nil {
-		pb.parentGroup.addProfile(p)
-	}
-
 	return p
 }
 
@@ -533,6 +1729,21 @@ func (pb ProfileBuilder) Copy() *ProfileBuilder {
 		composite:     pb.composite,
 		memory:        pb.memory,
 		nThreads:      pb.nThreads,
+
+		defaultCondition: pb.defaultCondition,
+		approxQuantiles:  append([]float64(nil), pb.approxQuantiles...),
+		warmup:           pb.warmup,
+		trackAlloc:       pb.trackAlloc,
+		timerTimeout:     pb.timerTimeout,
+		callerInfo:       pb.callerInfo,
+		parallelismFloor: pb.parallelismFloor,
+		decayHalfLife:    pb.decayHalfLife,
+		memoryThreshold:  pb.memoryThreshold,
+		description:      pb.description,
+		gcAttribution:    pb.gcAttribution,
+		rollup:           pb.rollup,
+		cpuTime:          pb.cpuTime,
+		statsEngine:      pb.statsEngine,
 	}
 	return cpb
 }
@@ -551,12 +1762,39 @@ func (pb *ProfileBuilder) WithParentGroup(g *GroupSt) *ProfileBuilder {
 // Any profile generated calling [NewProfile] will be added to the sub-profiles
 // of profile p.
 // If pb had a parent group, it will be deleted.
+// If the ancestors of p form a cycle, which would make the generated profiles
+// loop forever when traversed, an error is logged and pb is not modified.
 func (pb *ProfileBuilder) WithParentProfile(p *ProfileSt) *ProfileBuilder {
+	if p != nil && p.hasCyclicAncestry() {
+		getLogger().Error("attempt to create a profile cycle detected, parent not set",
+			slog.String("profile", p.name))
+		return pb
+	}
 	pb.parentProfile = p
 	pb.parentGroup = nil
 	return pb
 }
 
+// WithParentPath modifies and returns pb, setting its parent profile to the
+// profile of group g identified by path, i.e., the profile:
+//
+//	g.Profile(path[0]).Profile(path[1])...Profile(path[len(path)-1])
+//
+// Missing profiles along the path are created (see [GroupSt.Profile] and
+// [ProfileSt.Profile]). If path is empty it is equivalent to
+// [ProfileBuilder.WithParentGroup].
+func (pb *ProfileBuilder) WithParentPath(g *GroupSt, path ...string) *ProfileBuilder {
+	if len(path) == 0 {
+		return pb.WithParentGroup(g)
+	}
+
+	p := g.Profile(path[0])
+	for _, name := range path[1:] {
+		p = p.Profile(name)
+	}
+	return pb.WithParentProfile(p)
+}
+
 // AddComposition modifies and returns pb, making any new profile generated
 // by calling [ProfileBuilder.NewProfile] a composite profile.
 func (pb *ProfileBuilder) AddComposition() *ProfileBuilder {
@@ -589,7 +1827,7 @@ func (pb *ProfileBuilder) RemoveMemory() *ProfileBuilder {
 // by calling [ProfileBuilder.NewProfile] a multi-threaded profile with n threads,
 // where n is the number of cores (see [SetCoresNumber]).
 func (pb *ProfileBuilder) AddMultiThreading() *ProfileBuilder {
-	pb.nThreads = cores
+	pb.nThreads = conf().cores
 	return pb
 }
 
@@ -602,14 +1840,15 @@ func (pb *ProfileBuilder) RemoveMultiThreading() *ProfileBuilder {
 
 // WithNThreads modifies and returns pb, making any new profile generated
 // by calling [ProfileBuilder.NewProfile] a multi-threaded profile with n threads.
-// If n > number of cores (see [SetCoresNumber]) then n is set to the number of cores.
+// If n > number of cores (see [SetCoresNumber]) then n is set to the number of
+// cores, unless overcommit is allowed (see [SetAllowOvercommit]).
 func (pb *ProfileBuilder) WithNThreads(n uint64) *ProfileBuilder {
 	if n <= 0 {
-		logger.Error("number of threads must be > 0, setting value to 1")
+		getLogger().Error("number of threads must be > 0, setting value to 1")
 		n = 1
 	}
 
-	if n > cores {
+	if cores := conf().cores; n > cores && !conf().overcommit {
 		n = cores
 	}
 
@@ -621,10 +1860,194 @@ func (pb *ProfileBuilder) WithNThreads(n uint64) *ProfileBuilder {
 // on n.
 func (pb *ProfileBuilder) WithNCores(n uint64) *ProfileBuilder {
 	if n <= 0 {
-		logger.Error("number of threads must be > 0, setting value to 1")
+		getLogger().Error("number of threads must be > 0, setting value to 1")
 		n = 1
 	}
 
 	pb.nThreads = n
 	return pb
 }
+
+// WithDefaultCondition modifies and returns pb, making any new profile generated
+// by calling [ProfileBuilder.NewProfile] use name as the condition for samples
+// recorded without specifying one (see [Timer.Stop]).
+// If name is empty the global default is used (see [SetDefaultConditionName]).
+func (pb *ProfileBuilder) WithDefaultCondition(name string) *ProfileBuilder {
+	pb.defaultCondition = name
+	return pb
+}
+
+func (pb ProfileBuilder) defaultConditionName() string {
+	if pb.defaultCondition != "" {
+		return pb.defaultCondition
+	}
+	return conf().defaultConditionName
+}
+
+// WithApproxPercentiles modifies and returns pb, making any new profile generated
+// by calling [ProfileBuilder.NewProfile] estimate the quantiles qs (0 < q < 1)
+// of its runtimes in constant memory, using the P² algorithm.
+// This allows [ProfileSt.Percentile] to return an approximation for the quantiles
+// qs even for memoryless profiles. Calling it without arguments disables the
+// estimation.
+func (pb *ProfileBuilder) WithApproxPercentiles(qs ...float64) *ProfileBuilder {
+	for _, q := range qs {
+		if q <= 0 || q >= 1 {
+			getLogger().Error("invalid quantile, must be in (0, 1)",
+				slog.Float64("q", q))
+			return pb
+		}
+	}
+
+	pb.approxQuantiles = append([]float64(nil), qs...)
+	return pb
+}
+
+// WithWarmup modifies and returns pb, making any new non-composite profile
+// generated by calling [ProfileBuilder.NewProfile] discard the first n samples
+// it records, e.g., to exclude cold cache effects from the statistics.
+// Discarded samples still trigger the callbacks registered using
+// [ProfileSt.OnSample].
+func (pb *ProfileBuilder) WithWarmup(n uint64) *ProfileBuilder {
+	pb.warmup = n
+	return pb
+}
+
+// WithAllocTracking modifies and returns pb, making timers of any new profile
+// generated by calling [ProfileBuilder.NewProfile] record the number of bytes
+// allocated on the heap when started, so that allocations can be measured
+// using [Timer.StopWithAlloc].
+func (pb *ProfileBuilder) WithAllocTracking() *ProfileBuilder {
+	pb.trackAlloc = true
+	return pb
+}
+
+// WithTimerTimeout modifies and returns pb, making timers of any new profile
+// generated by calling [ProfileBuilder.NewProfile] be watched: a warning,
+// including the stack trace of the StartTimer call, is logged if a timer is
+// not stopped within d, which typically reveals a missing Stop.
+// Each timer is reported at most once. A non-positive d disables the watch.
+func (pb *ProfileBuilder) WithTimerTimeout(d time.Duration) *ProfileBuilder {
+	if d < 0 {
+		d = 0
+	}
+	pb.timerTimeout = d
+	return pb
+}
+
+// WithCallerInfo modifies and returns pb, making any new profile generated by
+// calling [ProfileBuilder.NewProfile] record the source location (file:line)
+// of its creation, i.e., of the innermost call outside this package. The
+// location is reported by String, in the titles of the printed tables, by
+// snapshots and by the JSON exports.
+// Sub-profiles created automatically by a Stop call record the location of
+// that call.
+func (pb *ProfileBuilder) WithCallerInfo() *ProfileBuilder {
+	pb.callerInfo = true
+	return pb
+}
+
+// WithParallelismFloor modifies and returns pb, making any new multi-threaded
+// profile generated by calling [ProfileBuilder.NewProfile] divide its runtime
+// by the number of threads only once at least minSamples samples have been
+// recorded: until then the effective runtime equals the total runtime, since
+// few samples hardly ran in parallel.
+func (pb *ProfileBuilder) WithParallelismFloor(minSamples uint64) *ProfileBuilder {
+	pb.parallelismFloor = minSamples
+	return pb
+}
+
+// WithDecay modifies and returns pb, making the mean runtime of any new
+// memoryless profile generated by calling [ProfileBuilder.NewProfile] an
+// exponentially weighted moving average, where the weight of a sample halves
+// every halfLife elapsed since its end. The mean runtime then tracks recent
+// behaviour, while total and effective runtime and number of samples remain
+// cumulative. Memory full profiles are not affected.
+// A non-positive halfLife disables the decay.
+func (pb *ProfileBuilder) WithDecay(halfLife time.Duration) *ProfileBuilder {
+	if halfLife < 0 {
+		halfLife = 0
+	}
+	pb.decayHalfLife = halfLife
+	return pb
+}
+
+// WithMemoryIfSlowerThan modifies and returns pb, making any new memoryless
+// profile generated by calling [ProfileBuilder.NewProfile] become memory full
+// as soon as its mean runtime exceeds d, i.e., right after recording the
+// sample that raises the mean above d. From then on samples are retained,
+// e.g., for percentile analysis, while the statistics accumulated before are
+// preserved: exact percentiles only account for the retained samples.
+// A non-positive d disables the switch.
+func (pb *ProfileBuilder) WithMemoryIfSlowerThan(d time.Duration) *ProfileBuilder {
+	if d < 0 {
+		d = 0
+	}
+	pb.memoryThreshold = d
+	return pb
+}
+
+// WithDescription modifies and returns pb, making any new profile generated by
+// calling [ProfileBuilder.NewProfile] have description s (see
+// [ProfileSt.SetDescription]).
+func (pb *ProfileBuilder) WithDescription(s string) *ProfileBuilder {
+	pb.description = s
+	return pb
+}
+
+// WithGCAttribution modifies and returns pb, making timers of any new profile
+// generated by calling [ProfileBuilder.NewProfile] record the number of
+// completed garbage collection cycles when started and stopped, so that the
+// samples spanning a GC are counted (see [ProfileSt.GCAffectedSamples]).
+// Since GCs are program-wide, a sample is counted even if the timed code did
+// not trigger the cycle.
+func (pb *ProfileBuilder) WithGCAttribution() *ProfileBuilder {
+	pb.gcAttribution = true
+	return pb
+}
+
+// WithRollup modifies and returns pb, making any new composite profile
+// generated by calling [ProfileBuilder.NewProfile] aggregate the statistics of
+// its sub-profiles using fn instead of summing them (see [RollupFunc]).
+// A nil fn restores the summation.
+func (pb *ProfileBuilder) WithRollup(fn RollupFunc) *ProfileBuilder {
+	pb.rollup = fn
+	return pb
+}
+
+// WithCPUTime modifies and returns pb, making timers of any new profile
+// generated by calling [ProfileBuilder.NewProfile] measure the CPU time
+// consumed by the thread running them, alongside the wall-clock time (see
+// [ProfileSt.MeanCPUTime]).
+// The CPU time of a thread is only meaningful if the timed goroutine is not
+// moved to another thread while the timer is running, e.g., because it calls
+// [runtime.LockOSThread]: otherwise the measure includes the CPU time of other
+// goroutines, or is meaningless. For this reason handed off timers (see
+// [Timer.Handoff]) do not measure the CPU time.
+// On platforms where the CPU time of threads is not available, currently all
+// but Linux, a warning is logged and pb is not modified.
+func (pb *ProfileBuilder) WithCPUTime() *ProfileBuilder {
+	if _, ok := readThreadCPUTime(); !ok {
+		getLogger().Warn("thread CPU time not available on this platform, only wall-clock time is measured")
+		return pb
+	}
+	pb.cpuTime = true
+	return pb
+}
+
+// WithStatsEngine modifies and returns pb, making any new profile generated by
+// calling [ProfileBuilder.NewProfile] feed its samples to its own copy of
+// engine (see [StatsEngine]).
+// The engine replaces the built-in statistics of non-composite profiles: only
+// their number of samples is maintained, their samples are not retained and
+// their other built-in statistics, e.g., runtimes and percentiles, are zero
+// and displayed as N/A. The statistics of the engines of composite profiles
+// are merged from the ones of their sub-profiles.
+// The statistics computed by the engines are displayed by the Print functions
+// in a separate table and exported by [ProfileSt.Snapshot] and the JSON
+// exports, see [ProfileSt.EngineSnapshot].
+// A nil engine restores the built-in statistics.
+func (pb *ProfileBuilder) WithStatsEngine(engine StatsEngine) *ProfileBuilder {
+	pb.statsEngine = engine
+	return pb
+}
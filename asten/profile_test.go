@@ -0,0 +1,504 @@
+package asten
+
+import (
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestParentCycleRejected(t *testing.T) {
+	restoreConfig(t)
+	SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	a := NewProfile("a", WithComposite())
+	b := a.Profile("b")
+	// wiring that no exported function produces, e.g., a corrupted tree
+	a.parent = b
+
+	pb := NewProfileBuilder()
+	if pb.WithParentProfile(b).parentProfile != nil {
+		t.Error("builder parent with cyclic ancestors accepted")
+	}
+
+	builder := b.builder
+	b.SetBuilder(NewProfileBuilder())
+	if b.builder != builder {
+		t.Error("builder of a profile with cyclic ancestors replaced")
+	}
+
+	a.parent = nil
+	if pb.WithParentProfile(b).parentProfile != b {
+		t.Error("builder parent without cycles refused")
+	}
+}
+
+func TestParentOfOwnBuilder(t *testing.T) {
+	p := NewProfile("p", WithComposite())
+	pb := p.Builder()
+
+	// the parent of the builder is set to the profiles it generates, which
+	// are nested rather than becoming ancestors of their parent
+	q := pb.NewProfile("q")
+	r := pb.WithParentProfile(q).NewProfile("r")
+	q.SetBuilder(pb)
+	s := q.Builder().NewProfile("s")
+
+	if got := r.getFullName(); got != "p -> q -> r" {
+		t.Errorf("full name %q, want %q", got, "p -> q -> r")
+	}
+	if got := s.getFullName(); got != "p -> q -> s" {
+		t.Errorf("full name %q, want %q", got, "p -> q -> s")
+	}
+}
+
+func TestTimeFuncPanic(t *testing.T) {
+	p := NewProfile("p")
+
+	var recovered any
+	func() {
+		defer func() { recovered = recover() }()
+		p.TimeFunc(func() { panic("boom") })
+	}()
+
+	if recovered != "boom" {
+		t.Errorf("recovered %v, want the panic of fn", recovered)
+	}
+	if n := p.Snapshot().NSamples; n != 1 {
+		t.Errorf("NSamples = %d, want 1", n)
+	}
+	if r := p.ErrorRate(); r != 1 {
+		t.Errorf("ErrorRate = %v, want 1", r)
+	}
+
+	p.TimeFunc(func() {})
+	if n := p.Snapshot().NSamples; n != 2 {
+		t.Errorf("NSamples = %d, want 2", n)
+	}
+	if r := p.ErrorRate(); r != 0.5 {
+		t.Errorf("ErrorRate = %v, want 0.5", r)
+	}
+}
+
+// backwardClock goes back in time by a second at each call
+type backwardClock struct {
+	now time.Time
+}
+
+func (c *backwardClock) Now() time.Time {
+	c.now = c.now.Add(-time.Second)
+	return c.now
+}
+
+func TestIncrUntimed(t *testing.T) {
+	p := NewProfile("cache", WithComposite())
+	record(p, 2*time.Millisecond, "miss")
+	mean := p.Snapshot().MeanTime
+
+	for i := 0; i < 3; i++ {
+		p.Incr("hit")
+	}
+
+	snap := p.Snapshot()
+	if snap.MeanTime != mean {
+		t.Errorf("mean time %v after Incr, want %v", snap.MeanTime, mean)
+	}
+	if snap.NSamples != 4 {
+		t.Errorf("NSamples = %d, want 4", snap.NSamples)
+	}
+	if r := p.ConditionRate("hit"); r != 0.75 {
+		t.Errorf("hit rate %v, want 0.75", r)
+	}
+}
+
+func TestInvertedSpan(t *testing.T) {
+	restoreConfig(t)
+	SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p := NewProfile("p")
+	var notified []time.Duration
+	p.OnSample(func(d time.Duration, conds []string) {
+		notified = append(notified, d)
+	})
+
+	start := time.Unix(100, 0)
+	p.RecordBatch([]Span{{Start: start, End: start.Add(-time.Second)}})
+
+	SetClock(&backwardClock{now: start})
+	p.StartTimer().Stop()
+
+	snap := p.Snapshot()
+	if snap.NSamples != 2 {
+		t.Errorf("NSamples = %d, want 2", snap.NSamples)
+	}
+	if snap.TotalTime != 0 {
+		t.Errorf("TotalTime = %v, want 0", snap.TotalTime)
+	}
+	for _, d := range p.Samples() {
+		if d != 0 {
+			t.Errorf("sample lasting %v, want 0", d)
+		}
+	}
+	if len(notified) != 2 {
+		t.Fatalf("%d callbacks, want 2", len(notified))
+	}
+	for _, d := range notified {
+		if d != 0 {
+			t.Errorf("callback notified of %v, want 0", d)
+		}
+	}
+}
+
+func TestDurationScaleReported(t *testing.T) {
+	p := NewProfile("p", WithComposite())
+	p.SetDurationScale(0.5)
+	var notified time.Duration
+	p.OnSample(func(d time.Duration, conds []string) {
+		notified = d
+	})
+
+	record(p, 10*time.Millisecond, "a")
+
+	if notified != 5*time.Millisecond {
+		t.Errorf("callback notified of %v, want 5ms", notified)
+	}
+	snap := p.Snapshot()
+	if snap.Scale != 0.5 || snap.SubProfiles[0].Scale != 0.5 {
+		t.Errorf("snapshot scales %v and %v, want 0.5", snap.Scale, snap.SubProfiles[0].Scale)
+	}
+	if snap.TotalTime != 5*time.Millisecond {
+		t.Errorf("TotalTime = %v, want 5ms", snap.TotalTime)
+	}
+	if unscaled := NewProfile("q").Snapshot().Scale; unscaled != 1 {
+		t.Errorf("unscaled snapshot has scale %v, want 1", unscaled)
+	}
+}
+
+func TestSeriesBucketCap(t *testing.T) {
+	restoreConfig(t)
+	SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p := NewProfile("p", WithMemory())
+	start := time.Unix(0, 0)
+	p.RecordBatch([]Span{
+		{Start: start, End: start.Add(time.Second)},
+		{Start: start, End: start.Add(3 * time.Second)},
+	})
+
+	if got := p.Series(time.Second); len(got) != 3 || got[0].Count != 1 || got[1].Count != 0 || got[2].Count != 1 {
+		t.Errorf("Series(1s) = %v, want 3 buckets holding 1, 0 and 1 samples", got)
+	}
+	if got := p.Series(time.Nanosecond); got != nil {
+		t.Errorf("Series(1ns) returned %d buckets, want nil", len(got))
+	}
+
+	// spans whose duration cannot be represented
+	far := NewProfile("far", WithMemory())
+	far.RecordBatch([]Span{
+		{Start: time.Unix(0, 0), End: time.Unix(0, 0)},
+		{Start: time.Unix(1<<40, 0), End: time.Unix(1<<40, 0)},
+	})
+	if got := far.Series(time.Hour); got != nil {
+		t.Errorf("Series over centuries returned %d buckets, want nil", len(got))
+	}
+}
+
+func TestBuilderStringOmitsUnset(t *testing.T) {
+	s := NewProfileBuilder().String()
+	for _, line := range []string{"gc attribution", "custom rollup", "cpu time", "stats engine"} {
+		if strings.Contains(s, line) {
+			t.Errorf("unset %q printed:\n%s", line, s)
+		}
+	}
+
+	s = NewProfileBuilder().
+		WithGCAttribution().
+		WithRollup(func(children []ProfileSnapshot) ProfileSnapshot { return ProfileSnapshot{} }).
+		WithStatsEngine(&countEngine{}).
+		String()
+	for _, line := range []string{"gc attribution: true", "custom rollup: true", "stats engine: *asten.countEngine"} {
+		if !strings.Contains(s, line) {
+			t.Errorf("%q not printed:\n%s", line, s)
+		}
+	}
+}
+
+func TestMaxDepthComposite(t *testing.T) {
+	restoreConfig(t)
+	SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	g := NewUnregisteredGroup("g", WithComposite())
+	empty, other, withDefault := g.Profile("empty"), g.Profile("other"), g.Profile("default")
+	record(other, time.Millisecond, "x")
+	record(withDefault, time.Millisecond)
+	SetMaxProfileDepth(1)
+
+	// recorded by the composite profile itself, made non-composite
+	if got := empty.ExplainStopAs("y"); got != "empty" {
+		t.Errorf("ExplainStopAs = %q, want %q", got, "empty")
+	}
+	empty.StartTimer().StopAs("y")
+	if empty.composite || empty.SubProfileCount() != 0 || empty.Snapshot().NSamples != 1 {
+		t.Errorf("empty composite: composite %t, %d sub-profiles, %d samples, want false, 0 and 1",
+			empty.composite, empty.SubProfileCount(), empty.Snapshot().NSamples)
+	}
+
+	// no profile to record the sample without creating one
+	if got := other.ExplainStopAs("y"); got != "" {
+		t.Errorf("ExplainStopAs = %q, want discarded", got)
+	}
+	other.StartTimer().Stop()
+	other.StartTimer().StopAs("x")
+	if other.SubProfileCount() != 1 || other.Snapshot().NSamples != 2 {
+		t.Errorf("composite without default: %d sub-profiles, %d samples, want 1 and 2",
+			other.SubProfileCount(), other.Snapshot().NSamples)
+	}
+
+	// recorded by the existing default condition
+	base := conf().defaultConditionName
+	if got, want := withDefault.ExplainStopAs("y", "z"), "default -> "+base; got != want {
+		t.Errorf("ExplainStopAs = %q, want %q", got, want)
+	}
+	withDefault.StartTimer().StopAs("y", "z")
+	if withDefault.SubProfileCount() != 1 || withDefault.Profile(base).Snapshot().NSamples != 2 {
+		t.Errorf("composite with default: %d sub-profiles, %d samples in %s, want 1 and 2",
+			withDefault.SubProfileCount(), withDefault.Profile(base).Snapshot().NSamples, base)
+	}
+}
+
+func TestResetCondition(t *testing.T) {
+	restoreConfig(t)
+	SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p := NewProfile("p", WithComposite())
+	record(p, time.Millisecond, "ok")
+	record(p, time.Millisecond, "ok")
+	record(p, 4*time.Millisecond, "status=500", "db")
+	start := time.Unix(0, 0)
+	if last := p.LastSampleAt(); !last.Equal(start.Add(4 * time.Millisecond)) {
+		t.Errorf("last sample at %v, want %v", last, start.Add(4*time.Millisecond))
+	}
+
+	p.ResetCondition("status=500")
+	if last := p.LastSampleAt(); !last.Equal(start.Add(time.Millisecond)) {
+		t.Errorf("last sample at %v after reset, want %v", last, start.Add(time.Millisecond))
+	}
+	if p.HasConditionPath("status=500") {
+		t.Error("samples of the reset condition preserved")
+	}
+	snap := p.Snapshot()
+	if snap.NSamples != 2 || snap.MeanTime != time.Millisecond {
+		t.Errorf("NSamples = %d, mean %v, want 2 and %v", snap.NSamples, snap.MeanTime, time.Millisecond)
+	}
+	if r := p.ConditionRate("ok"); r != 1 {
+		t.Errorf("ok rate %v, want 1", r)
+	}
+
+	// unknown paths are ignored
+	p.ResetCondition("missing")
+	if n := p.Snapshot().NSamples; n != 2 {
+		t.Errorf("NSamples = %d after resetting a missing condition, want 2", n)
+	}
+
+	p.ResetCondition()
+	if last := p.LastSampleAt(); !last.IsZero() {
+		t.Errorf("last sample at %v after resetting p, want none", last)
+	}
+}
+
+func TestRecentPercentile(t *testing.T) {
+	p := NewProfile("p", WithMemory())
+	start := time.Unix(0, 0)
+	var spans []Span
+	// slow samples followed by fast ones
+	for i := 0; i < 5; i++ {
+		s := start.Add(time.Duration(i) * time.Second)
+		spans = append(spans, Span{Start: s, End: s.Add(10 * time.Millisecond)})
+	}
+	for i := 5; i < 8; i++ {
+		s := start.Add(time.Duration(i) * time.Second)
+		spans = append(spans, Span{Start: s, End: s.Add(time.Millisecond)})
+	}
+	p.RecordBatch(spans)
+
+	if d := p.RecentPercentile(3, 1); d != time.Millisecond {
+		t.Errorf("max of the 3 most recent samples %v, want %v", d, time.Millisecond)
+	}
+	if d := p.RecentPercentile(4, 1); d != 10*time.Millisecond {
+		t.Errorf("max of the 4 most recent samples %v, want %v", d, 10*time.Millisecond)
+	}
+	if d := p.RecentPercentile(100, 0.5); d != p.Percentile(0.5) {
+		t.Errorf("median of a window larger than the samples %v, want %v", d, p.Percentile(0.5))
+	}
+
+	memoryless := NewProfile("memoryless")
+	memoryless.RecordBatch(spans)
+	if d := memoryless.RecentPercentile(3, 1); d != 0 {
+		t.Errorf("memoryless recent percentile %v, want 0", d)
+	}
+}
+
+// stepClock moves forward by step at each call
+type stepClock struct {
+	now  time.Time
+	step time.Duration
+}
+
+func (c *stepClock) Now() time.Time {
+	c.now = c.now.Add(c.step)
+	return c.now
+}
+
+func TestStopMulti(t *testing.T) {
+	restoreConfig(t)
+	SetClock(&stepClock{now: time.Unix(0, 0), step: 10 * time.Millisecond})
+
+	p := NewProfile("request", WithComposite())
+	p.StartTimer().StopMulti([]string{"serialization"}, []string{"total"}, nil)
+
+	for _, conds := range [][]string{{"serialization"}, {"total"}, {"base"}} {
+		if !p.HasConditionPath(conds...) {
+			t.Errorf("no sample recorded as %v", conds)
+		}
+	}
+	// the sample is counted once per target
+	snap := p.Snapshot()
+	if snap.NSamples != 3 || snap.TotalTime != 30*time.Millisecond {
+		t.Errorf("NSamples = %d, total %v, want 3 and %v", snap.NSamples, snap.TotalTime, 30*time.Millisecond)
+	}
+	for _, sp := range snap.SubProfiles {
+		if sp.TotalTime != 10*time.Millisecond {
+			t.Errorf("%s total %v, want %v", sp.Name, sp.TotalTime, 10*time.Millisecond)
+		}
+	}
+
+	// without targets the sample is registered as by Stop
+	q := NewProfile("q", WithComposite())
+	q.StartTimer().StopMulti()
+	if !q.HasConditionPath("base") || q.Snapshot().NSamples != 1 {
+		t.Error("sample without targets not registered under the default condition")
+	}
+}
+
+func TestStopWeighted(t *testing.T) {
+	restoreConfig(t)
+	SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	SetClock(&stepClock{now: time.Unix(0, 0), step: 10 * time.Millisecond})
+
+	p := NewProfile("request", WithComposite())
+	p.StartTimer().StopWeighted(map[string]float64{"parse": 1, "query": 1, "render": 1, "skipped": 0, "negative": -1})
+
+	snap := p.Snapshot()
+	if len(snap.SubProfiles) != 3 {
+		t.Fatalf("%d conditions recorded, want 3", len(snap.SubProfiles))
+	}
+	// the shares are rounded but sum to the measured duration
+	var sum time.Duration
+	for _, sp := range snap.SubProfiles {
+		if d := sp.TotalTime - 10*time.Millisecond/3; d < -1 || d > 1 {
+			t.Errorf("%s lasting %v, want about %v", sp.Name, sp.TotalTime, 10*time.Millisecond/3)
+		}
+		sum += sp.TotalTime
+	}
+	if sum != 10*time.Millisecond || snap.TotalTime != 10*time.Millisecond {
+		t.Errorf("shares summing to %v, total %v, want %v", sum, snap.TotalTime, 10*time.Millisecond)
+	}
+
+	q := NewProfile("q", WithComposite())
+	q.StartTimer().StopWeighted(map[string]float64{"a": 0})
+	if n := q.Snapshot().NSamples; n != 0 {
+		t.Errorf("NSamples = %d without positive weights, want 0", n)
+	}
+}
+
+func TestSubProfileCountAndDepth(t *testing.T) {
+	p := NewProfile("p", WithComposite())
+	if n, d := p.SubProfileCount(), p.Depth(); n != 0 || d != 0 {
+		t.Errorf("empty profile: %d sub-profiles, depth %d, want 0 and 0", n, d)
+	}
+
+	record(p, time.Millisecond, "a")
+	record(p, time.Millisecond, "b", "c", "d")
+	record(p, time.Millisecond, "b", "e")
+
+	if n := p.SubProfileCount(); n != 2 {
+		t.Errorf("%d sub-profiles, want 2", n)
+	}
+	if d := p.Depth(); d != 3 {
+		t.Errorf("depth %d, want 3", d)
+	}
+	b := p.Profile("b")
+	if n, d := b.SubProfileCount(), b.Depth(); n != 2 || d != 2 {
+		t.Errorf("b: %d sub-profiles, depth %d, want 2 and 2", n, d)
+	}
+	// the ancestors are not accounted for
+	if d := b.Profile("e").Depth(); d != 0 {
+		t.Errorf("leaf depth %d, want 0", d)
+	}
+}
+
+func TestCollapseDefaultCondition(t *testing.T) {
+	restoreConfig(t)
+
+	p := NewProfile("p", WithComposite())
+	record(p, time.Millisecond, "base")
+	record(p, 3*time.Millisecond, "slow")
+	q := NewProfile("q", WithComposite())
+	record(q, time.Millisecond, "base", "cached")
+	record(q, 3*time.Millisecond, "slow")
+
+	if n := len(p.Snapshot().SubProfiles); n != 2 {
+		t.Errorf("%d sub-profiles in the snapshot, want 2", n)
+	}
+
+	SetCollapseDefaultCondition(true)
+	snap := p.Snapshot()
+	if len(snap.SubProfiles) != 1 || snap.SubProfiles[0].Name != "p -> slow" {
+		t.Errorf("sub-profiles %v, want only the slow one", snap.SubProfiles)
+	}
+	// the collapsed condition is still accounted for by p
+	if snap.NSamples != 2 || snap.TotalTime != 4*time.Millisecond {
+		t.Errorf("NSamples = %d, total %v, want 2 and %v", snap.NSamples, snap.TotalTime, 4*time.Millisecond)
+	}
+	// composite default conditions are never collapsed
+	if n := len(q.Snapshot().SubProfiles); n != 2 {
+		t.Errorf("%d sub-profiles with a composite default condition, want 2", n)
+	}
+}
+
+func TestCPUTime(t *testing.T) {
+	if _, ok := readThreadCPUTime(); !ok {
+		t.Skip("thread CPU time not available")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	p := NewProfile("p", WithComposite(), WithCPUTime())
+	tm := p.StartTimer()
+	for deadline := time.Now().Add(20 * time.Millisecond); time.Now().Before(deadline); {
+	}
+	tm.StopAs("busy")
+	tm = p.StartTimer()
+	time.Sleep(20 * time.Millisecond)
+	tm.StopAs("sleeping")
+
+	busy, sleeping := p.Profile("busy"), p.Profile("sleeping")
+	if cpu := busy.MeanCPUTime(); cpu <= 0 || cpu > busy.Snapshot().MeanTime {
+		t.Errorf("busy CPU time %v, want in (0, %v]", cpu, busy.Snapshot().MeanTime)
+	}
+	if cpu := sleeping.MeanCPUTime(); cpu > sleeping.Snapshot().MeanTime/2 {
+		t.Errorf("sleeping CPU time %v, want well below %v", cpu, sleeping.Snapshot().MeanTime)
+	}
+	if cpu, want := p.MeanCPUTime(), (busy.MeanCPUTime()+sleeping.MeanCPUTime())/2; cpu != want {
+		t.Errorf("composite CPU time %v, want %v", cpu, want)
+	}
+
+	wall := NewProfile("wall")
+	wall.StartTimer().Stop()
+	if cpu := wall.MeanCPUTime(); cpu != 0 {
+		t.Errorf("CPU time %v without WithCPUTime, want 0", cpu)
+	}
+}
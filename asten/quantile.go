@@ -0,0 +1,113 @@
+package asten
+
+import (
+	"math"
+
+	"golang.org/x/exp/slices"
+)
+
+// p2Estimator estimates a quantile of a stream of values in constant memory
+// using the P² algorithm (R. Jain and I. Chlamtac, "The P² algorithm for
+// dynamic calculation of quantiles and histograms without storing
+// observations", 1985).
+type p2Estimator struct {
+	q     float64
+	count int
+
+	h  [5]float64 // marker heights
+	n  [5]float64 // marker positions
+	np [5]float64 // desired marker positions
+	dn [5]float64 // increments of the desired positions
+}
+
+func newP2Estimator(q float64) *p2Estimator {
+	return &p2Estimator{
+		q:  q,
+		dn: [5]float64{0, q / 2, q, (1 + q) / 2, 1},
+	}
+}
+
+func (e *p2Estimator) copy() *p2Estimator {
+	ce := *e
+	return &ce
+}
+
+// add adds x to the observed values.
+func (e *p2Estimator) add(x float64) {
+	if e.count < 5 {
+		e.h[e.count] = x
+		e.count++
+		if e.count == 5 {
+			slices.Sort(e.h[:])
+			e.n = [5]float64{1, 2, 3, 4, 5}
+			e.np = [5]float64{1, 1 + 2*e.q, 1 + 4*e.q, 3 + 2*e.q, 5}
+		}
+		return
+	}
+	e.count++
+
+	// find the cell containing x, adjusting the extreme markers
+	var k int
+	switch {
+	case x < e.h[0]:
+		e.h[0] = x
+		k = 0
+	case x >= e.h[4]:
+		e.h[4] = x
+		k = 3
+	default:
+		for k = 0; k < 3 && x >= e.h[k+1]; k++ {
+		}
+	}
+
+	for i := k + 1; i < 5; i++ {
+		e.n[i]++
+	}
+	for i := range e.np {
+		e.np[i] += e.dn[i]
+	}
+
+	// adjust the heights of the middle markers if necessary
+	for i := 1; i <= 3; i++ {
+		d := e.np[i] - e.n[i]
+		if (d >= 1 && e.n[i+1]-e.n[i] > 1) || (d <= -1 && e.n[i-1]-e.n[i] < -1) {
+			d = math.Copysign(1, d)
+			h := e.parabolic(i, d)
+			if e.h[i-1] >= h || h >= e.h[i+1] {
+				h = e.linear(i, d)
+			}
+			e.h[i] = h
+			e.n[i] += d
+		}
+	}
+}
+
+func (e *p2Estimator) parabolic(i int, d float64) float64 {
+	return e.h[i] + d/(e.n[i+1]-e.n[i-1])*
+		((e.n[i]-e.n[i-1]+d)*(e.h[i+1]-e.h[i])/(e.n[i+1]-e.n[i])+
+			(e.n[i+1]-e.n[i]-d)*(e.h[i]-e.h[i-1])/(e.n[i]-e.n[i-1]))
+}
+
+func (e *p2Estimator) linear(i int, d float64) float64 {
+	j := i + int(d)
+	return e.h[i] + d*(e.h[j]-e.h[i])/(e.n[j]-e.n[i])
+}
+
+// value returns the current estimate of the quantile, false if no value has
+// been observed.
+func (e *p2Estimator) value() (float64, bool) {
+	if e.count == 0 {
+		return 0, false
+	}
+	if e.count < 5 {
+		// exact quantile of the few values observed
+		vs := append([]float64(nil), e.h[:e.count]...)
+		slices.Sort(vs)
+		i := int(math.Ceil(e.q*float64(len(vs)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return vs[i], true
+	}
+	return e.h[2], true
+}
@@ -0,0 +1,107 @@
+package asten
+
+import (
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+)
+
+// # ReportConfig
+//
+// Contains the settings of the tables printed for a group (see
+// [GroupSt.SetReportConfig]), overriding the global ones.
+type ReportConfig struct {
+	// Columns are the displayed columns, nil means the ones set using
+	// [SetColumns]
+	Columns []Column
+	// SortBy is the metric ordering the rows of the tables, by name by default
+	SortBy SortKey
+	// Descending reverses the order of the rows
+	Descending bool
+	// HideEmpty hides the profiles without samples
+	HideEmpty bool
+}
+
+// defaultReportConfig returns the settings of the tables of the groups without
+// a report config, i.e., the global ones.
+func defaultReportConfig() ReportConfig {
+	return ReportConfig{Columns: conf().columns}
+}
+
+// SetReportConfig sets the settings of the tables printed for group g and its
+// profiles by the Print functions, so that groups of the same program can be
+// presented differently, e.g., HTTP latencies sorted by p99:
+//
+//	g.SetReportConfig(ReportConfig{SortBy: SortByP99, Descending: true})
+//
+// An error is logged, and the settings of g are left unchanged, if rc contains
+// invalid columns or sort key.
+func (g *GroupSt) SetReportConfig(rc ReportConfig) {
+	if rc.Columns != nil {
+		if !validColumns(rc.Columns) {
+			return
+		}
+		rc.Columns = append([]Column(nil), rc.Columns...)
+	}
+	if rc.SortBy < 0 || rc.SortBy >= numSortKeys {
+		getLogger().Error("invalid sort key",
+			slog.Int("key", int(rc.SortBy)))
+		return
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	g.report = &rc
+}
+
+// ResetReportConfig makes the tables printed for group g follow the global
+// settings again (see [GroupSt.SetReportConfig]).
+func (g *GroupSt) ResetReportConfig() {
+	g.Lock()
+	defer g.Unlock()
+
+	g.report = nil
+}
+
+// reportConfig returns the settings of the tables of g, whose report config,
+// if any, is completed with the global settings.
+func (g *GroupSt) reportConfig() ReportConfig {
+	if g.report == nil {
+		return defaultReportConfig()
+	}
+
+	rc := *g.report
+	if rc.Columns == nil {
+		rc.Columns = conf().columns
+	}
+	return rc
+}
+
+// order returns the names, sorted by name, of the (already updated) profiles
+// to be displayed in the given order.
+func (rc ReportConfig) order(profiles map[string]*ProfileSt, names []string) []string {
+	ordered := make([]string, 0, len(names))
+	for _, name := range names {
+		if rc.HideEmpty && profiles[name].stats.count() == 0 {
+			continue
+		}
+		ordered = append(ordered, name)
+	}
+
+	if rc.SortBy != SortByName {
+		// stable, so that ties are sorted by name
+		slices.SortStableFunc(ordered, func(a, b string) bool {
+			ma, _ := rc.SortBy.metric(profiles[a])
+			mb, _ := rc.SortBy.metric(profiles[b])
+			if rc.Descending {
+				return ma > mb
+			}
+			return ma < mb
+		})
+	} else if rc.Descending {
+		for i, j := 0, len(ordered)-1; i < j; i, j = i+1, j-1 {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		}
+	}
+	return ordered
+}
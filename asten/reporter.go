@@ -0,0 +1,70 @@
+package asten
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// # Reporter
+//
+// Reporter is an output sink receiving snapshots of the groups, e.g., to
+// write them to a file or to push them to a monitoring system. Reporters are
+// registered using [RegisterReporter] and invoked by [ReportAll].
+type Reporter interface {
+	Report(snap GroupSnapshot) error
+}
+
+// ReporterFunc is an adapter allowing ordinary functions to be used as
+// Reporters.
+type ReporterFunc func(snap GroupSnapshot) error
+
+// Report calls f(snap).
+func (f ReporterFunc) Report(snap GroupSnapshot) error {
+	return f(snap)
+}
+
+var (
+	// reporters contains the registered reporters
+	reporters []Reporter
+	// reportersLock manages access to reporters
+	reportersLock sync.RWMutex
+)
+
+// RegisterReporter adds r to the reporters invoked by [ReportAll].
+func RegisterReporter(r Reporter) {
+	if r == nil {
+		getLogger().Error("cannot register a nil reporter")
+		return
+	}
+
+	reportersLock.Lock()
+	defer reportersLock.Unlock()
+
+	reporters = append(reporters, r)
+}
+
+// ReportAll takes a single snapshot of every declared group (see [Groups])
+// and passes it to each registered reporter, in order of registration.
+// All the reporters are invoked even if some of them fail: the returned error
+// joins the errors of the failed ones.
+func ReportAll() error {
+	reportersLock.RLock()
+	rs := append([]Reporter(nil), reporters...)
+	reportersLock.RUnlock()
+
+	if len(rs) == 0 {
+		return nil
+	}
+
+	var errs []error
+	for _, g := range Groups() {
+		snap := g.Snapshot()
+		for _, r := range rs {
+			if err := r.Report(snap); err != nil {
+				errs = append(errs, fmt.Errorf("reporting group %s: %w", snap.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,91 @@
+package asten
+
+import "time"
+
+// selfGroupName is the name of the group exposing the overhead of asten
+// itself, see EnableSelfProfiling
+const selfGroupName = "__asten__"
+
+// EnableSelfProfiling makes asten measure the time spent waiting for its own
+// locks while recording samples, e.g., when stopping timers, so that the
+// perturbation caused by the instrumentation can be quantified.
+// The measures are recorded in the profile "lock wait" of the group
+// "__asten__", under the conditions:
+//   - "route": finding and locking the profile in which the sample is
+//     recorded, including the creation of missing sub-profiles;
+//   - "stats": locking the statistics of such profile.
+//
+// Measures rely on [time.Now] regardless of [SetClock].
+func EnableSelfProfiling() {
+	p := Group(selfGroupName, WithComposite()).Profile("lock wait")
+	updateConfig(func(c *Config) {
+		c.selfProfile = p
+	})
+}
+
+// DisableSelfProfiling stops the measures started by [EnableSelfProfiling].
+// The group "__asten__" is preserved.
+func DisableSelfProfiling() {
+	updateConfig(func(c *Config) {
+		c.selfProfile = nil
+	})
+}
+
+// lockWait contains the instants at which the locks needed to record a sample
+// were requested and acquired, see EnableSelfProfiling.
+type lockWait struct {
+	self   *ProfileSt // nil if self profiling is disabled
+	start  time.Time
+	routed time.Time
+	locked time.Time
+}
+
+// lockLeaf returns the non-composite profile in which samples with conditions
+// conds must be registered (see [ProfileSt.route]), locked together with its
+// statistics, and the time spent acquiring the locks. nil is returned if the
+// samples must be discarded.
+func (p *ProfileSt) lockLeaf(conds []string) (*ProfileSt, lockWait) {
+	w := lockWait{self: conf().selfProfile}
+	if w.self != nil {
+		w.start = time.Now()
+	}
+
+	leaf := p.route(conds)
+	if leaf == nil {
+		return nil, lockWait{}
+	}
+	if w.self != nil {
+		w.routed = time.Now()
+	}
+
+	leaf.stats.Lock()
+	if w.self != nil {
+		w.locked = time.Now()
+	}
+	return leaf, w
+}
+
+// record records w in the self profile, if enabled. It must be called after
+// the locks have been released.
+func (w lockWait) record() {
+	if w.self == nil {
+		return
+	}
+	w.self.recordSelf("route", w.start, w.routed)
+	w.self.recordSelf("stats", w.routed, w.locked)
+}
+
+// recordSelf records a sample in the sub-profile cond of the self profile p,
+// bypassing lockLeaf so that self profiling does not measure itself.
+func (p *ProfileSt) recordSelf(cond string, start, end time.Time) {
+	leaf := p.route([]string{cond})
+	if leaf == nil {
+		return
+	}
+
+	leaf.stats.Lock()
+	leaf.stats.registerSample(newSample(start, end, false))
+
+	leaf.Unlock()
+	leaf.stats.Unlock()
+}
@@ -0,0 +1,166 @@
+package asten
+
+import (
+	"math"
+	"regexp"
+	"time"
+
+	"github.com/fatih/color"
+	"golang.org/x/exp/slog"
+)
+
+// SetSLO sets the latency objective of profile p: at least the fraction
+// quantile of the samples recorded by p (or by its descendants if p is
+// composite) must last at most target, e.g., 99% of the requests within 100ms:
+//
+//	p.SetSLO(100*time.Millisecond, 0.99)
+//
+// The rows of the profiles violating their objective are displayed in red by
+// the Print functions (see [ProfileSt.MeetsSLO]).
+// A zero target removes the objective. An error is logged, and the objective
+// is left unchanged, if target is negative or quantile is not in (0, 1].
+func (p *ProfileSt) SetSLO(target time.Duration, quantile float64) {
+	if target < 0 || (target > 0 && !(quantile > 0 && quantile <= 1)) {
+		getLogger().Error("invalid SLO",
+			slog.String("profile", p.getFullName()),
+			slog.Duration("target", target),
+			slog.Float64("quantile", quantile))
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.sloTarget = target
+	p.sloQuantile = quantile
+}
+
+// SLOCompliance returns the fraction of the samples recorded by profile p (or
+// by its descendants if p is composite) lasting at most the target of its
+// objective (see [ProfileSt.SetSLO]).
+// If some of the samples are not retained, e.g., for memoryless profiles, it
+// is the probability of a sample meeting the target, estimated by a normal
+// distribution with the mean and variance of the samples (see
+// [ProfileSt.StdDev]). If their variance is unknown, e.g., for statistics set
+// using [ProfileSt.SetAggregate], only their mean runtime is compared to the
+// target: the compliance is 1 if it is met, 0 otherwise.
+// It returns 1 if p has no objective or no samples.
+func (p *ProfileSt) SLOCompliance() float64 {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	return p.sloCompliance()
+}
+
+// MeetsSLO returns whether profile p meets its objective (see
+// [ProfileSt.SetSLO]), i.e., its compliance (see [ProfileSt.SLOCompliance]) is
+// at least the quantile of the objective. It returns true if p has no
+// objective.
+func (p *ProfileSt) MeetsSLO() bool {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	return !p.violatesSLO()
+}
+
+// violatesSLO returns whether the (already updated) profile p has an objective
+// and does not meet it.
+func (p *ProfileSt) violatesSLO() bool {
+	if p.sloTarget == 0 {
+		return false
+	}
+	return p.sloCompliance() < p.sloQuantile-1e-9
+}
+
+// sloCompliance returns the compliance of the (already updated) profile p, see
+// SLOCompliance.
+func (p *ProfileSt) sloCompliance() float64 {
+	if p.sloTarget == 0 {
+		return 1
+	}
+
+	met, n, ok := p.countWithin(p.sloTarget)
+	if !ok {
+		return p.stats.probabilityWithin(p.sloTarget)
+	}
+	if n == 0 {
+		return 1
+	}
+	return float64(met) / float64(n)
+}
+
+// countWithin returns the number of samples recorded by p or by its
+// descendants lasting at most target, and the number of samples. It returns
+// false if some of the samples are not retained.
+func (p *ProfileSt) countWithin(target time.Duration) (uint64, uint64, bool) {
+	if !p.composite {
+		if !p.memory || p.stats.carriedN > 0 {
+			return 0, 0, false
+		}
+		var met uint64
+		for _, s := range p.stats.samples {
+			if s.getDurationNano() <= uint64(target) {
+				met++
+			}
+		}
+		return met, uint64(len(p.stats.samples)), true
+	}
+
+	var met, n uint64
+	for _, sp := range p.subProfiles {
+		spMet, spN, ok := sp.countWithin(target)
+		if !ok {
+			return 0, 0, false
+		}
+		met += spMet
+		n += spN
+	}
+	return met, n, true
+}
+
+// probabilityWithin returns the probability of a sample lasting at most
+// target, assuming the sample durations are normally distributed, 1 if no
+// sample has been recorded. If the variance is unknown the durations are
+// assumed to equal their mean.
+func (s *profileStats) probabilityWithin(target time.Duration) float64 {
+	mean, sd := s.varMean, s.stdDev()
+	if s.varN == 0 {
+		if s.nsamples == 0 {
+			return 1
+		}
+		// runtimes not divided by the threads, as the sample durations
+		mean, sd = s.totalTime.float()/float64(s.nsamples), 0
+	}
+
+	if sd == 0 {
+		if mean <= float64(target) {
+			return 1
+		}
+		return 0
+	}
+	z := (float64(target) - mean) / sd
+	return 0.5 * math.Erfc(-z/math.Sqrt2)
+}
+
+// ansiEscape matches the escape sequences used to color the cells of tables.
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// visibleWidth returns the width of s ignoring its color escape sequences, so
+// that colored rows are aligned (see SetSLO).
+func visibleWidth(s string) int {
+	return len([]rune(ansiEscape.ReplaceAllString(s, "")))
+}
+
+// sloColored returns cells colored in red if the (already updated) profile p
+// violates its objective, unchanged otherwise.
+func sloColored(p *ProfileSt, cells []interface{}) []interface{} {
+	if !p.violatesSLO() {
+		return cells
+	}
+	for i, c := range cells {
+		cells[i] = color.RedString("%v", c)
+	}
+	return cells
+}
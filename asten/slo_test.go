@@ -0,0 +1,30 @@
+package asten
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSLOUnknownVariance(t *testing.T) {
+	fast := NewProfile("fast")
+	fast.SetAggregate(50*time.Millisecond, 50*time.Millisecond, 10)
+	fast.SetSLO(10*time.Millisecond, 0.99)
+
+	slow := NewProfile("slow")
+	slow.SetAggregate(500*time.Millisecond, 500*time.Millisecond, 10)
+	slow.SetSLO(10*time.Millisecond, 0.99)
+
+	if c := fast.SLOCompliance(); c != 1 || !fast.MeetsSLO() {
+		t.Errorf("mean of 5ms: compliance %v, want the 10ms target met", c)
+	}
+	if c := slow.SLOCompliance(); c != 0 || slow.MeetsSLO() {
+		t.Errorf("mean of 50ms: compliance %v, want the 10ms target violated", c)
+	}
+
+	// samples recorded afterwards provide the variance
+	record(slow, 5*time.Millisecond)
+	record(slow, 7*time.Millisecond)
+	if c := slow.SLOCompliance(); !slow.MeetsSLO() {
+		t.Errorf("compliance %v after fast samples, want the target met", c)
+	}
+}
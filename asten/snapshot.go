@@ -0,0 +1,268 @@
+package asten
+
+import (
+	"path"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slog"
+)
+
+// # ProfileSnapshot
+//
+// Contains the statistics of a profile at a given time. Unlike [ProfileSt]
+// it is a plain value which is not affected by samples recorded after its
+// creation.
+type ProfileSnapshot struct {
+	Name          string
+	Description   string
+	TotalTime     time.Duration
+	EffectiveTime time.Duration
+	MeanTime      time.Duration
+	NSamples      uint64
+	Timeslice     float64
+	Taken         float64
+	// Location is the source location where the profile was created, empty
+	// unless recorded (see [ProfileBuilder.WithCallerInfo])
+	Location string
+	// Scale is the factor the durations of the samples are multiplied by, 1 if
+	// they are measured (see [ProfileSt.SetDurationScale])
+	Scale float64
+	// SubProfiles contains the snapshots of the sub-profiles, sorted by name,
+	// except the default condition if collapsed (see
+	// [SetCollapseDefaultCondition]).
+	// It is only filled by snapshots of whole trees, e.g., [GroupSt.Snapshot]
+	// and [ProfileSt.Snapshot].
+	SubProfiles []ProfileSnapshot
+	// Engine contains the custom statistics of the profile, nil if it has no
+	// stats engine (see [ProfileBuilder.WithStatsEngine])
+	Engine map[string]float64
+}
+
+// # GroupSnapshot
+//
+// Contains the statistics of a group and of its profiles at a given time (see
+// [GroupSt.Snapshot]).
+type GroupSnapshot struct {
+	Name          string
+	TotalTime     time.Duration
+	EffectiveTime time.Duration
+	NSamples      uint64
+	// Labels contains the labels of the group, see [GroupSt.SetLabels]
+	Labels map[string]string
+	// Profiles contains the snapshots of the profiles of the group, sorted by
+	// name, including their sub-profiles
+	Profiles []ProfileSnapshot
+}
+
+// # RollupFunc
+//
+// Computes the statistics of a composite profile from the snapshots of its
+// sub-profiles, sorted by name (see [ProfileBuilder.WithRollup]). Only the
+// TotalTime, EffectiveTime and NSamples of the returned snapshot are used, the
+// mean runtime being derived from them. By default composite profiles sum the
+// statistics of their sub-profiles. For example, the effective runtime of a
+// profile whose sub-profiles model stages running in parallel is the one of
+// the slowest stage:
+//
+//	func(children []ProfileSnapshot) ProfileSnapshot {
+//		var agg ProfileSnapshot
+//		for _, c := range children {
+//			agg.TotalTime += c.TotalTime
+//			if c.EffectiveTime > agg.EffectiveTime {
+//				agg.EffectiveTime = c.EffectiveTime
+//			}
+//			if c.NSamples > agg.NSamples {
+//				agg.NSamples = c.NSamples
+//			}
+//		}
+//		return agg
+//	}
+type RollupFunc func(children []ProfileSnapshot) ProfileSnapshot
+
+// Snapshot returns a snapshot of group g, its profiles and their descendants.
+func (g *GroupSt) Snapshot() GroupSnapshot {
+	g.recursiveLock()
+	defer g.recursiveUnlock()
+	g.update()
+
+	snap := GroupSnapshot{
+		Name:          g.name,
+		TotalTime:     g.stats.totalTime.duration(),
+		EffectiveTime: g.stats.effectiveTime.duration(),
+		NSamples:      g.stats.nsamples,
+		Labels:        maps.Clone(g.labels),
+	}
+	for _, pname := range sortedKeys(g.profiles) {
+		snap.Profiles = append(snap.Profiles, g.profiles[pname].treeSnapshot())
+	}
+	return snap
+}
+
+// Snapshot returns a snapshot of profile p and its descendants. Being the
+// root of the snapshot, timeslice and branch taken of p are relative to p
+// itself, i.e., 1 unless p has no samples or no runtime.
+func (p *ProfileSt) Snapshot() ProfileSnapshot {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	snap := p.treeSnapshot()
+	snap.Timeslice = p.stats.effectiveTime.ratio(p.stats.effectiveTime)
+	snap.Taken = ratio(p.stats.count(), p.stats.count())
+	return snap
+}
+
+// treeSnapshot returns the snapshot of p including the ones of its
+// descendants. Statistics must be up to date.
+func (p *ProfileSt) treeSnapshot() ProfileSnapshot {
+	snap := p.snapshot()
+	for _, spName := range p.displayedSubProfiles() {
+		snap.SubProfiles = append(snap.SubProfiles, p.subProfiles[spName].treeSnapshot())
+	}
+	return snap
+}
+
+// snapshot returns the snapshot of p, whose statistics must be up to date.
+func (p *ProfileSt) snapshot() ProfileSnapshot {
+	return ProfileSnapshot{
+		Name:          p.getFullName(),
+		Description:   p.description,
+		Location:      p.location,
+		TotalTime:     p.stats.totalTime.duration(),
+		EffectiveTime: p.stats.effectiveTime.duration(),
+		MeanTime:      time.Duration(p.stats.meanTime),
+		NSamples:      p.stats.count(),
+		Timeslice:     p.stats.timeslice,
+		Taken:         p.stats.taken,
+		Scale:         p.durationScale(),
+		Engine:        p.engineSnapshot(),
+	}
+}
+
+// Aggregate returns a snapshot of profile p where runtimes and number of
+// samples are computed over all the samples recorded by its non-composite
+// descendants, as if p were non-composite.
+// If p is non-composite it simply returns its snapshot.
+func (p *ProfileSt) Aggregate() ProfileSnapshot {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	var a aggregator
+	a.add(p)
+
+	snap := p.snapshot()
+	a.fill(&snap)
+	return snap
+}
+
+// ConditionBreakdown returns the snapshots of the sub-profiles of p keyed by
+// their condition, e.g., "status=200" and "status=500" for samples recorded
+// using StopAs("status=200") and StopAs("status=500"). Timeslice and branch
+// taken of the snapshots are relative to p.
+// It returns nil if p is non-composite.
+func (p *ProfileSt) ConditionBreakdown() map[string]ProfileSnapshot {
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	if !p.composite {
+		return nil
+	}
+
+	breakdown := make(map[string]ProfileSnapshot, len(p.subProfiles))
+	for cond, sp := range p.subProfiles {
+		breakdown[cond] = sp.snapshot()
+	}
+	return breakdown
+}
+
+// AggregateMatching is equivalent to [ProfileSt.Aggregate] but only the
+// descendants of p whose path relative to p matches pattern are considered.
+// The relative path of a sub-profile is obtained by joining with "/" the
+// conditions leading to it, e.g. the path of "p -> status=500 -> db" is
+// "status=500/db". The pattern syntax is the one of [path.Match], e.g.:
+//
+//	p.AggregateMatching("status=5*")
+//	p.AggregateMatching("*/db")
+//
+// The name of the returned snapshot is the full name of p followed by the pattern.
+func (p *ProfileSt) AggregateMatching(pattern string) ProfileSnapshot {
+	if _, err := path.Match(pattern, ""); err != nil {
+		getLogger().Error("invalid pattern",
+			slog.String("pattern", pattern),
+			slog.String("error", err.Error()))
+		return ProfileSnapshot{}
+	}
+
+	p.recursiveLock()
+	defer p.recursiveUnlock()
+	p.update()
+
+	var a aggregator
+	p.forEachMatching(pattern, "", &a)
+
+	snap := ProfileSnapshot{
+		Name:  p.getFullName() + " -> " + pattern,
+		Scale: p.durationScale(),
+	}
+	a.fill(&snap)
+	return snap
+}
+
+// forEachMatching adds to a the descendants of p whose relative path matches
+// pattern. Descendants of matching profiles are not further inspected.
+func (p *ProfileSt) forEachMatching(pattern, prefix string, a *aggregator) {
+	for _, spName := range sortedKeys(p.subProfiles) {
+		sp := p.subProfiles[spName]
+		rel := prefix + spName
+		if ok, _ := path.Match(pattern, rel); ok {
+			a.add(sp)
+			continue
+		}
+		sp.forEachMatching(pattern, rel+"/", a)
+	}
+}
+
+// aggregator sums the statistics of non-composite profiles
+type aggregator struct {
+	total     wideSum
+	effective wideSum
+	n         uint64
+	events    uint64
+}
+
+// add adds to a the statistics of the non-composite descendants of p (or of p
+// itself if it is non-composite).
+func (a *aggregator) add(p *ProfileSt) {
+	p.forEachLeaf(func(leaf *ProfileSt) {
+		a.total.add(leaf.stats.totalTime)
+		a.effective.add(leaf.stats.effectiveTime)
+		a.n += leaf.stats.nsamples
+		a.events += leaf.stats.events
+	})
+}
+
+// fill sets runtimes and number of samples of snap to the aggregated ones.
+func (a aggregator) fill(snap *ProfileSnapshot) {
+	snap.TotalTime = a.total.duration()
+	snap.EffectiveTime = a.effective.duration()
+	snap.NSamples = a.n + a.events
+	snap.MeanTime = 0
+	if a.n > 0 {
+		snap.MeanTime = a.effective.div(a.n).duration()
+	}
+}
+
+// forEachLeaf calls fn on each non-composite descendant of p, or on p itself
+// if it is non-composite.
+func (p *ProfileSt) forEachLeaf(fn func(leaf *ProfileSt)) {
+	if !p.composite {
+		fn(p)
+		return
+	}
+	for _, spName := range sortedKeys(p.subProfiles) {
+		p.subProfiles[spName].forEachLeaf(fn)
+	}
+}
@@ -0,0 +1,105 @@
+package asten
+
+import "golang.org/x/exp/slog"
+
+// # SortKey
+//
+// Represents a metric of the profiles, used to sort or aggregate them, e.g.,
+// by [GroupSt.Sum].
+type SortKey int
+
+const (
+	SortByName SortKey = iota
+	SortByTotalTime
+	SortByEffectiveTime
+	SortByMeanTime
+	SortByNSamples
+	SortByTimeslice
+	SortByP99
+
+	numSortKeys // number of available keys, must be last
+)
+
+func (k SortKey) String() string {
+	switch k {
+	case SortByName:
+		return "name"
+	case SortByTotalTime:
+		return "total runtime"
+	case SortByEffectiveTime:
+		return "effective runtime"
+	case SortByMeanTime:
+		return "mean runtime"
+	case SortByNSamples:
+		return "nsamples"
+	case SortByTimeslice:
+		return "timeslice"
+	case SortByP99:
+		return "p99"
+	}
+	return "unknown"
+}
+
+// metric returns the value of k for the (already updated) profile p, runtimes
+// in nanoseconds. It returns false if k is not numeric.
+func (k SortKey) metric(p *ProfileSt) (float64, bool) {
+	switch k {
+	case SortByTotalTime:
+		return p.stats.totalTime.float(), true
+	case SortByEffectiveTime:
+		return p.stats.effectiveTime.float(), true
+	case SortByMeanTime:
+		return p.stats.meanTime, true
+	case SortByNSamples:
+		return float64(p.stats.count()), true
+	case SortByTimeslice:
+		return p.stats.timeslice, true
+	case SortByP99:
+		// 0 if not available, see Percentile
+		d, _ := p.percentile(0.99)
+		return float64(d), true
+	}
+	return 0, false
+}
+
+// Sum returns the sum of metric by over the profiles of group g, runtimes in
+// nanoseconds, e.g.:
+//
+//	time.Duration(g.Sum(SortByEffectiveTime))
+//
+// by must be numeric: for SortByName an error is logged and 0 is returned.
+func (g *GroupSt) Sum(by SortKey) float64 {
+	sum, _ := g.sum(by)
+	return sum
+}
+
+// Average returns the mean of metric by over the profiles of group g (see
+// [GroupSt.Sum]), e.g., the mean of the mean runtimes for SortByMeanTime.
+// It returns 0 if g has no profiles.
+func (g *GroupSt) Average(by SortKey) float64 {
+	sum, n := g.sum(by)
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// sum returns the sum of metric by over the profiles of g and their number.
+func (g *GroupSt) sum(by SortKey) (float64, int) {
+	g.recursiveLock()
+	defer g.recursiveUnlock()
+	g.update()
+
+	var sum float64
+	for _, p := range g.profiles {
+		v, ok := by.metric(p)
+		if !ok {
+			getLogger().Error("metric cannot be summed",
+				slog.String("group", g.name),
+				slog.String("metric", by.String()))
+			return 0, 0
+		}
+		sum += v
+	}
+	return sum, len(g.profiles)
+}
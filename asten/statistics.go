@@ -3,7 +3,10 @@ package asten
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"math/bits"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/exp/slog"
@@ -12,10 +15,12 @@ import (
 type groupStats struct {
 	*sync.RWMutex
 	group *GroupSt
-	valid bool
+	// valid is atomic since it is reset by the profiles of the group while
+	// recording samples, without holding the group statistics lock
+	valid atomic.Bool
 
-	totalTime     uint64
-	effectiveTime uint64
+	totalTime     wideSum
+	effectiveTime wideSum
 	nsamples      uint64
 }
 
@@ -23,9 +28,8 @@ func newGroupStats(g *GroupSt) *groupStats {
 	gd := &groupStats{
 		RWMutex:       &sync.RWMutex{},
 		group:         g,
-		valid:         false,
-		totalTime:     0,
-		effectiveTime: 0,
+		totalTime:     wideSum{},
+		effectiveTime: wideSum{},
 		nsamples:      0,
 	}
 
@@ -36,91 +40,151 @@ func (gs *groupStats) String() string {
 	var b bytes.Buffer
 
 	b.WriteString("[statistics]\n")
-	b.WriteString(fmt.Sprintf("valid: %t\n", gs.valid))
-	b.WriteString(fmt.Sprintf("totalTime: %s\n", time.Duration(gs.totalTime)))
-	b.WriteString(fmt.Sprintf("effectiveTime: %s\n", time.Duration(gs.effectiveTime)))
+	b.WriteString(fmt.Sprintf("valid: %t\n", gs.valid.Load()))
+	b.WriteString(fmt.Sprintf("totalTime: %s\n", gs.totalTime.duration()))
+	b.WriteString(fmt.Sprintf("effectiveTime: %s\n", gs.effectiveTime.duration()))
 	b.WriteString(fmt.Sprintf("nsamples: %d\n", gs.nsamples))
 
 	return b.String()
 }
 
 func (s *groupStats) update() {
-	if s.valid {
-		return
+	if !s.valid.Load() {
+		s.valid.Store(true)
+
+		s.totalTime = wideSum{}
+		s.effectiveTime = wideSum{}
+		s.nsamples = 0
+
+		for spName := range s.group.profiles {
+			subStats := s.group.profiles[spName].stats
+			s.totalTime.add(subStats.totalTime)
+			s.effectiveTime.add(subStats.effectiveTime)
+			s.nsamples += subStats.count()
+		}
 	}
 
-	s.valid = true
-
-	s.totalTime = 0
-	s.effectiveTime = 0
-	s.nsamples = 0
-
+	// ratios are always recomputed since profiles shared with other groups
+	// (see GroupSt.AttachProfile) may have been updated relatively to them
 	for spName := range s.group.profiles {
 		subStats := s.group.profiles[spName].stats
-		s.totalTime += subStats.totalTime
-		s.effectiveTime += subStats.effectiveTime
-		s.nsamples += subStats.nsamples
+		subStats.timeslice = subStats.effectiveTime.ratio(s.effectiveTime)
+		subStats.taken = ratio(subStats.count(), s.nsamples)
 	}
+}
 
-	for spName := range s.group.profiles {
-		subStats := s.group.profiles[spName].stats
-		subStats.timeslice = float64(subStats.effectiveTime) / float64(s.effectiveTime)
-		subStats.taken = float64(subStats.nsamples) / float64(s.nsamples)
+// ratio returns part / whole, 0 if whole is 0, e.g., for timeslices when only
+// untimed samples have been recorded (see [ProfileSt.Incr]).
+func ratio(part, whole uint64) float64 {
+	if whole == 0 {
+		return 0
 	}
+	return float64(part) / float64(whole)
 }
 
 func (gs *groupStats) copy() *groupStats {
-	return &groupStats{
+	cgs := &groupStats{
 		RWMutex:       &sync.RWMutex{},
 		group:         gs.group,
-		valid:         gs.valid,
 		totalTime:     gs.totalTime,
 		effectiveTime: gs.effectiveTime,
 		nsamples:      gs.nsamples,
 	}
+	cgs.valid.Store(gs.valid.Load())
+
+	return cgs
 }
 
 type profileStats struct {
 	*sync.RWMutex
 	profile *ProfileSt
-	valid   bool
+	// valid is atomic since it is reset by the descendants of the profile,
+	// which do not hold its lock
+	valid atomic.Bool
 
-	totalTime     uint64
-	effectiveTime uint64
-	meanTime      uint64
+	totalTime     wideSum
+	effectiveTime wideSum
+	meanTime      float64 // nanoseconds
 	nsamples      uint64
+	events        uint64 // untimed samples, see ProfileSt.Incr
 	timeslice     float64
 	taken         float64
-
-	samples []sample
+	failures      uint64
+	totalAlloc    uint64    // bytes, see StopWithAlloc
+	gcAffected    uint64    // samples spanning a GC, see WithGCAttribution
+	totalCPU      uint64    // nanoseconds, see WithCPUTime
+	warmupLeft    uint64    // samples still to be discarded, see WithWarmup
+	lastSample    time.Time // end of the most recent sample
+
+	// statistics accumulated while memoryless before samples started being
+	// retained, see WithMemoryIfSlowerThan
+	carriedTotal     wideSum
+	carriedEffective wideSum
+	carriedN         uint64
+
+	// runtime and longest of the samples recorded while memoryless, excluding
+	// the carried ones, see leafEffective
+	sampledTotal wideSum
+	longest      uint64
+
+	// exponentially weighted moving average state, see WithDecay
+	decayWeight float64   // sum of the decayed weights of the samples
+	lastEnd     time.Time // end of the latest sample
+
+	// moments of the sample durations computed using Welford's algorithm,
+	// regardless of memory and decay, see StdDev
+	varN    uint64
+	varMean float64 // nanoseconds
+	varM2   float64 // sum of the squared deviations from varMean
+
+	samples   []sample
+	quantiles []*p2Estimator   // approximate quantiles, see WithApproxPercentiles
+	engine    StatsEngine      // custom statistics, see WithStatsEngine
+	budget    *retentionHandle // nil if not accounted, see SetGlobalSampleBudget
+
+	clampWarned bool // whether clamped runtimes have been reported, see addTime
 }
 
 func newProfileStats(p *ProfileSt) *profileStats {
 	ps := &profileStats{
-		RWMutex:       &sync.RWMutex{},
-		profile:       p,
-		valid:         true,
-		totalTime:     0,
-		effectiveTime: 0,
-		meanTime:      0,
-		nsamples:      0,
-		timeslice:     0,
+		RWMutex: &sync.RWMutex{},
 	}
+	ps.init(p)
 
 	return ps
 }
 
+// init initializes the zero statistics s of profile p.
+func (ps *profileStats) init(p *ProfileSt) {
+	ps.profile = p
+	ps.valid.Store(true)
+
+	ps.engine = cloneEngine(p.statsEngine)
+
+	if !p.composite {
+		ps.warmupLeft = p.warmup
+		for _, q := range p.approxQuantiles {
+			ps.quantiles = append(ps.quantiles, newP2Estimator(q))
+		}
+	}
+}
+
 func (ps *profileStats) String() string {
 	var b bytes.Buffer
 
 	b.WriteString("[statistics]\n")
-	b.WriteString(fmt.Sprintf("valid: %t\n", ps.valid))
-	b.WriteString(fmt.Sprintf("totalTime: %s\n", time.Duration(ps.totalTime)))
-	b.WriteString(fmt.Sprintf("effectiveTime: %s\n", time.Duration(ps.effectiveTime)))
+	b.WriteString(fmt.Sprintf("valid: %t\n", ps.valid.Load()))
+	b.WriteString(fmt.Sprintf("totalTime: %s\n", ps.totalTime.duration()))
+	b.WriteString(fmt.Sprintf("effectiveTime: %s\n", ps.effectiveTime.duration()))
 	b.WriteString(fmt.Sprintf("meanTime: %s\n", time.Duration(ps.meanTime)))
 	b.WriteString(fmt.Sprintf("nsamples: %d\n", ps.nsamples))
-	b.WriteString(fmt.Sprintf("timeslice: %f\n", ps.timeslice))
-	b.WriteString(fmt.Sprintf("taken: %f\n", ps.taken))
+	b.WriteString(fmt.Sprintf("events: %d\n", ps.events))
+	b.WriteString(fmt.Sprintf("timeslice: %v\n", roundRatio(ps.timeslice)))
+	b.WriteString(fmt.Sprintf("taken: %v\n", roundRatio(ps.taken)))
+	b.WriteString(fmt.Sprintf("failures: %d\n", ps.failures))
+	b.WriteString(fmt.Sprintf("totalAlloc: %d\n", ps.totalAlloc))
+	b.WriteString(fmt.Sprintf("gcAffected: %d\n", ps.gcAffected))
+	b.WriteString(fmt.Sprintf("totalCPU: %s\n", time.Duration(ps.totalCPU)))
 
 	return b.String()
 }
@@ -129,21 +193,49 @@ func (ps *profileStats) copy() *profileStats {
 	cps := &profileStats{
 		RWMutex:       &sync.RWMutex{},
 		profile:       nil,
-		valid:         ps.valid,
 		totalTime:     ps.totalTime,
 		effectiveTime: ps.effectiveTime,
 		meanTime:      ps.meanTime,
 		nsamples:      ps.nsamples,
+		events:        ps.events,
 		timeslice:     ps.timeslice,
 		taken:         ps.taken,
-		samples:       ps.samples,
+		failures:      ps.failures,
+		totalAlloc:    ps.totalAlloc,
+		gcAffected:    ps.gcAffected,
+		totalCPU:      ps.totalCPU,
+		warmupLeft:    ps.warmupLeft,
+		lastSample:    ps.lastSample,
+
+		carriedTotal:     ps.carriedTotal,
+		carriedEffective: ps.carriedEffective,
+		carriedN:         ps.carriedN,
+		sampledTotal:     ps.sampledTotal,
+		longest:          ps.longest,
+		decayWeight:      ps.decayWeight,
+		lastEnd:          ps.lastEnd,
+		varN:             ps.varN,
+		varMean:          ps.varMean,
+		varM2:            ps.varM2,
+		samples:          append([]sample(nil), ps.samples...),
+	}
+
+	for _, e := range ps.quantiles {
+		cps.quantiles = append(cps.quantiles, e.copy())
 	}
+	cps.engine = cloneEngine(ps.engine)
+	cps.valid.Store(ps.valid.Load())
 
 	return cps
 }
 
 func (s *profileStats) invalidate() {
-	s.valid = false
+	s.valid.Store(false)
+
+	// groups containing the profile must recompute their statistics as well
+	for _, g := range s.profile.memberOf() {
+		g.stats.valid.Store(false)
+	}
 
 	pp := s.profile.parent
 	if pp != nil {
@@ -152,24 +244,29 @@ func (s *profileStats) invalidate() {
 }
 
 func (s *profileStats) update() {
-	if s.valid {
+	s.applyEvictions()
+	if s.valid.Load() {
 		return
 	}
 
-	s.valid = true
+	s.valid.Store(true)
 
 	if !s.profile.composite {
+		if s.replaced() {
+			// maintained by add, no sample is retained
+			return
+		}
 		if !s.profile.memory {
-			logger.Error(
+			getLogger().Error(
 				"invalid profile statistics state: non composite memoryless statistics should always be valid",
 				slog.String("profile", s.profile.getFullName()),
 			)
 			return
 		}
 
-		s.totalTime = 0
-		s.effectiveTime = 0
-		s.nsamples = uint64(len(s.samples))
+		s.totalTime = wideSum{}
+		s.effectiveTime = wideSum{}
+		s.nsamples = s.carriedN + uint64(len(s.samples))
 
 		if s.nsamples == 0 {
 			s.meanTime = 0
@@ -177,63 +274,536 @@ func (s *profileStats) update() {
 			return
 		}
 
+		var sampled wideSum
+		var longest uint64
 		for _, sample := range s.samples {
-			s.totalTime += sample.getDurationNano()
-		}
-		if s.nsamples < s.profile.nThreads {
-			s.effectiveTime = s.totalTime / s.nsamples
-		} else {
-			s.effectiveTime = s.totalTime / s.profile.nThreads
+			d := sample.getDurationNano()
+			s.addTime(&sampled, wideOf(d))
+			if d > longest {
+				longest = d
+			}
 		}
 
-		s.meanTime = s.effectiveTime / s.nsamples
+		s.totalTime = sampled
+		s.addTime(&s.totalTime, s.carriedTotal)
+		s.effectiveTime = s.leafEffective(sampled, longest)
+
+		s.meanTime = s.effectiveTime.float() / float64(s.nsamples)
 		return
 	}
 
-	s.totalTime = 0
-	s.effectiveTime = 0
+	// the runtimes of the sub-profiles already account for their threads, the
+	// number of threads of a composite profile must not be applied again
+	s.totalTime = wideSum{}
+	s.effectiveTime = wideSum{}
+	s.nsamples = 0
+	s.events = 0
+	s.failures = 0
+	s.totalAlloc = 0
+	s.gcAffected = 0
+	s.totalCPU = 0
+	s.lastSample = time.Time{}
+	s.varN, s.varMean, s.varM2 = 0, 0, 0
 
 	for spName := range s.profile.subProfiles {
 		subStats := s.profile.subProfiles[spName].stats
-		s.totalTime += subStats.totalTime
-		s.effectiveTime += subStats.effectiveTime
+		s.addTime(&s.totalTime, subStats.totalTime)
+		s.addTime(&s.effectiveTime, subStats.effectiveTime)
 		s.nsamples += subStats.nsamples
+		s.events += subStats.events
+		s.failures += subStats.failures
+		s.accumulate(&s.totalAlloc, subStats.totalAlloc)
+		s.gcAffected += subStats.gcAffected
+		s.accumulate(&s.totalCPU, subStats.totalCPU)
+		s.mergeMoments(subStats)
+		if subStats.lastSample.After(s.lastSample) {
+			s.lastSample = subStats.lastSample
+		}
 	}
 
-	s.meanTime = s.effectiveTime / s.nsamples
+	if s.engine != nil {
+		s.engine = emptyEngine(s.engine)
+		for spName := range s.profile.subProfiles {
+			if subEngine := s.profile.subProfiles[spName].stats.engine; subEngine != nil {
+				s.engine.Merge(subEngine)
+			}
+		}
+	}
+
+	if s.profile.rollup != nil {
+		s.applyRollup(s.profile.rollup)
+	}
+
+	s.meanTime = 0
+	if s.nsamples > 0 {
+		s.meanTime = s.effectiveTime.float() / float64(s.nsamples)
+	}
 
 	for spName := range s.profile.subProfiles {
 		subStats := s.profile.subProfiles[spName].stats
-		subStats.timeslice = float64(subStats.effectiveTime) / float64(s.effectiveTime)
-		subStats.taken = float64(subStats.nsamples) / float64(s.nsamples)
+		subStats.timeslice = subStats.effectiveTime.ratio(s.effectiveTime)
+		subStats.taken = ratio(subStats.count(), s.count())
+	}
+}
+
+// count returns the number of samples of the statistics s, including the
+// untimed ones, which are not accounted for by any runtime statistics.
+func (s *profileStats) count() uint64 {
+	return s.nsamples + s.events
+}
+
+// applyRollup replaces the runtimes and number of samples of the composite
+// statistics s with the ones computed by fn (see WithRollup) from the
+// sub-profiles, whose statistics must be up to date.
+func (s *profileStats) applyRollup(fn RollupFunc) {
+	children := make([]ProfileSnapshot, 0, len(s.profile.subProfiles))
+	for _, spName := range sortedKeys(s.profile.subProfiles) {
+		children = append(children, s.profile.subProfiles[spName].snapshot())
+	}
+
+	agg := fn(children)
+	if agg.TotalTime < 0 || agg.EffectiveTime < 0 {
+		getLogger().Error("rollup returned negative runtimes, summation used instead",
+			slog.String("profile", s.profile.getFullName()))
+		return
 	}
+	s.totalTime = wideOf(uint64(agg.TotalTime))
+	s.effectiveTime = wideOf(uint64(agg.EffectiveTime))
+	// the number of samples of the snapshots already includes untimed ones
+	s.nsamples = agg.NSamples
+	s.events = 0
 }
 
 func (s *profileStats) registerSample(sample sample) {
 	s.invalidate()
+	s.add(sample)
+
+	if !s.profile.memory {
+		s.valid.Store(true)
+	}
+}
+
+// registerEvent registers an untimed sample, see ProfileSt.Incr.
+func (s *profileStats) registerEvent() {
+	s.invalidate()
+	s.events++
+
+	if !s.profile.memory {
+		s.valid.Store(true)
+	}
+}
+
+// registerSamples is equivalent to calling registerSample for each sample, but
+// statistics are invalidated only once.
+func (s *profileStats) registerSamples(samples []sample) {
+	s.invalidate()
+	for _, sample := range samples {
+		s.add(sample)
+	}
+
+	if !s.profile.memory {
+		s.valid.Store(true)
+	}
+}
+
+// reset discards the samples and the statistics accumulated so far, as if the
+// profile had just been created.
+func (s *profileStats) reset() {
+	s.release()
+	p := s.profile
+	*s = profileStats{RWMutex: s.RWMutex}
+	s.init(p)
+
+	s.invalidate()
+	if !s.profile.memory {
+		s.valid.Store(true)
+	}
+}
+
+// setAggregate replaces the samples and the statistics of the non-composite
+// statistics s with the given runtimes and number of samples.
+func (s *profileStats) setAggregate(total, effective, n uint64) {
+	// the statistics derived from individual samples, e.g., failures, the
+	// quantiles or the engine state, are discarded along with the samples
+	s.release()
+	p := s.profile
+	*s = profileStats{RWMutex: s.RWMutex}
+	s.init(p)
+	s.invalidate()
+
+	s.totalTime = wideOf(total)
+	s.effectiveTime = wideOf(effective)
+	s.nsamples = n
+	// the following samples are accounted on top of the given statistics
+	s.carry()
+	if s.profile.memory {
+		// recomputed by update
+		return
+	}
+
+	if n > 0 {
+		s.meanTime = float64(effective) / float64(n)
+		// the moving average starts from the given mean, weighted as one
+		// sample
+		s.decayWeight = 1
+		s.lastEnd = conf().clock.Now()
+	}
+	s.valid.Store(true)
+}
+
+// add adds sample to the statistics, without invalidating them.
+func (s *profileStats) add(sample sample) {
+	if scale := s.profile.durationScale(); scale != 1 {
+		sample = sample.scaled(scale)
+	}
+	if sample.end.After(s.lastSample) {
+		s.lastSample = sample.end
+	}
+	if s.warmupLeft > 0 {
+		s.warmupLeft--
+		return
+	}
+	if s.replaced() {
+		// only the number of samples is maintained
+		s.engine.Record(sample.exported())
+		s.nsamples++
+		return
+	}
+
+	if sample.failed {
+		s.failures++
+	}
+	if sample.gcAffected {
+		s.gcAffected++
+	}
+	s.accumulate(&s.totalAlloc, sample.alloc)
+	s.accumulate(&s.totalCPU, sample.cpu)
+	for _, e := range s.quantiles {
+		e.add(float64(sample.getDurationNano()))
+	}
+	s.addMoments(float64(sample.getDurationNano()))
 	if s.profile.memory {
 		s.samples = append(s.samples, sample)
 		s.nsamples++
+		s.retain()
 		return
 	}
 
 	duration := sample.getDurationNano()
 	s.nsamples++
-	s.totalTime += duration
-	s.effectiveTime += duration / s.profile.nThreads
-	s.meanTime = s.effectiveTime / s.nsamples
-	s.valid = true
+	s.addTime(&s.totalTime, wideOf(duration))
+	s.addTime(&s.sampledTotal, wideOf(duration))
+	if duration > s.longest {
+		s.longest = duration
+	}
+	// recomputed rather than accumulated, as by update for memory full
+	// profiles, since the thread divisor applies to the whole runtime
+	s.effectiveTime = s.leafEffective(s.sampledTotal, s.longest)
+
+	if s.profile.decayHalfLife > 0 {
+		s.addDecayed(float64(duration)/float64(s.threadDivisor()), sample.end)
+	} else {
+		// exact, the effective runtime being a 128-bit sum
+		s.meanTime = s.effectiveTime.float() / float64(s.nsamples)
+	}
+
+	if d := s.profile.memoryThreshold; d > 0 && !s.profile.compacted && s.meanTime > float64(d) {
+		s.startRetaining()
+	}
+}
+
+// startRetaining makes the memoryless profile of s memory full, carrying the
+// statistics accumulated so far (see [ProfileBuilder.WithMemoryIfSlowerThan]).
+// The profile must be locked.
+func (s *profileStats) startRetaining() {
+	s.carry()
+	s.profile.memory = true
+	s.valid.Store(false)
+}
+
+// carry makes the statistics s computed so far carried, so that the samples
+// recorded afterwards are accounted on top of them.
+func (s *profileStats) carry() {
+	s.carriedTotal = s.totalTime
+	s.carriedEffective = s.effectiveTime
+	s.carriedN = s.nsamples
+	s.sampledTotal = wideSum{}
+	s.longest = 0
+}
+
+// leafEffective returns the effective runtime of the non-composite statistics
+// s, given the runtime of its samples not carried and the longest of them.
+// The thread divisor is applied once, here, to the whole runtime of
+// the samples: the effective runtime models the wall-clock time under perfect
+// parallelism, which cannot be shorter than the longest sample. Composite
+// statistics sum the effective runtimes of their sub-profiles, never dividing
+// them again.
+func (s *profileStats) leafEffective(sampled wideSum, longest uint64) wideSum {
+	effective := sampled.div(s.threadDivisor())
+	if effective.less(wideOf(longest)) {
+		effective = wideOf(longest)
+	}
+	// statistics accumulated before the samples, e.g., while memoryless
+	s.addTime(&effective, s.carriedEffective)
+	return effective
+}
+
+// addDecayed updates the exponentially weighted moving average of the
+// effective runtimes (see [ProfileBuilder.WithDecay]) with a sample of
+// effective runtime x ended at end. The weight of a sample halves every
+// half-life elapsed between its end and the end of the latest sample.
+func (s *profileStats) addDecayed(x float64, end time.Time) {
+	lambda := math.Ln2 / float64(s.profile.decayHalfLife)
+
+	w := 1.0
+	if dt := end.Sub(s.lastEnd); s.decayWeight == 0 || dt >= 0 {
+		// age the previous samples
+		s.decayWeight *= math.Exp(-lambda * float64(dt))
+		s.lastEnd = end
+	} else {
+		// the sample ended before the latest one, age it instead
+		w = math.Exp(lambda * float64(dt))
+	}
+
+	s.decayWeight += w
+	s.meanTime += w * (x - s.meanTime) / s.decayWeight
+}
+
+// addMoments adds the duration x, in nanoseconds, to the moments of s using
+// Welford's algorithm.
+func (s *profileStats) addMoments(x float64) {
+	s.varN++
+	delta := x - s.varMean
+	s.varMean += delta / float64(s.varN)
+	s.varM2 += delta * (x - s.varMean)
+}
+
+// mergeMoments adds the moments of o to the ones of s, using the parallel
+// variant of Welford's algorithm by Chan et al.
+func (s *profileStats) mergeMoments(o *profileStats) {
+	if o.varN == 0 {
+		return
+	}
+	n := s.varN + o.varN
+	delta := o.varMean - s.varMean
+	s.varMean += delta * float64(o.varN) / float64(n)
+	s.varM2 += o.varM2 + delta*delta*float64(s.varN)*float64(o.varN)/float64(n)
+	s.varN = n
+}
+
+// stdDev returns the unbiased standard deviation of the sample durations, in
+// nanoseconds, 0 if fewer than two samples have been recorded.
+func (s *profileStats) stdDev() float64 {
+	if s.varN < 2 {
+		return 0
+	}
+	return math.Sqrt(s.varM2 / float64(s.varN-1))
+}
+
+// stdErr returns the standard error of the mean of the sample durations, in
+// nanoseconds, 0 if fewer than two samples have been recorded.
+func (s *profileStats) stdErr() float64 {
+	if s.varN < 2 {
+		return 0
+	}
+	return s.stdDev() / math.Sqrt(float64(s.varN))
+}
+
+// threadDivisor returns the number of threads the runtime is divided by to
+// obtain the effective runtime: 1 until the profile has accumulated the
+// samples required by its parallelism floor (see
+// [ProfileBuilder.WithParallelismFloor]).
+func (s *profileStats) threadDivisor() uint64 {
+	if s.nsamples < s.profile.parallelismFloor {
+		return 1
+	}
+	return s.profile.nThreads
+}
+
+// accumulate adds v to *acc. In case of overflow *acc is saturated to
+// [math.MaxUint64] and an error is logged, only once.
+func (s *profileStats) accumulate(acc *uint64, v uint64) {
+	sum, ok := addSaturating(*acc, v)
+	if !ok && *acc != math.MaxUint64 {
+		getLogger().Error("profile statistics overflow, value saturated",
+			slog.String("profile", s.profile.getFullName()))
+	}
+	*acc = sum
+}
+
+// addTime adds v to the runtime *acc. A warning is logged, only once per
+// statistics, when *acc exceeds the range of [time.Duration], since the reported runtimes are
+// then clamped, unlike the means computed from them.
+func (s *profileStats) addTime(acc *wideSum, v wideSum) {
+	fitted := acc.fitsDuration()
+	acc.add(v)
+	if fitted && !acc.fitsDuration() && !s.clampWarned {
+		s.clampWarned = true
+		getLogger().Warn("profile runtime exceeds the range of time.Duration, reported value clamped",
+			slog.String("profile", s.profile.getFullName()))
+	}
+}
+
+// addSaturating returns a + b, or [math.MaxUint64] and false if the sum
+// overflows.
+func addSaturating(a, b uint64) (uint64, bool) {
+	sum, carry := bits.Add64(a, b, 0)
+	if carry != 0 {
+		return math.MaxUint64, false
+	}
+	return sum, true
+}
+
+// approxPercentile returns the estimate of quantile q, false if q is not
+// estimated or no sample has been recorded.
+func (s *profileStats) approxPercentile(q float64) (time.Duration, bool) {
+	for _, e := range s.quantiles {
+		if e.q == q {
+			v, ok := e.value()
+			return time.Duration(v), ok
+		}
+	}
+	return 0, false
+}
+
+// meanAlloc returns the mean number of bytes allocated per sample.
+func (s *profileStats) meanAlloc() uint64 {
+	if s.nsamples == 0 {
+		return 0
+	}
+	return s.totalAlloc / s.nsamples
+}
+
+// meanCPU returns the mean CPU time per sample, in nanoseconds.
+func (s *profileStats) meanCPU() uint64 {
+	if s.nsamples == 0 {
+		return 0
+	}
+	return s.totalCPU / s.nsamples
+}
+
+// errorRate returns the fraction of samples recorded as failed.
+func (s *profileStats) errorRate() float64 {
+	if s.nsamples == 0 {
+		return 0
+	}
+	return float64(s.failures) / float64(s.nsamples)
 }
 
 type sample struct {
-	start time.Time
-	end   time.Time
+	start  time.Time
+	end    time.Time
+	failed bool
+	alloc  uint64 // bytes allocated, see StopWithAlloc
+
+	gcAffected bool   // spans a GC cycle, see WithGCAttribution
+	cpu        uint64 // nanoseconds of CPU time, see WithCPUTime
 }
 
-func newSample(start, end time.Time) sample {
-	return sample{start: start, end: end}
+// newSample returns a sample ending at end. If end precedes start, e.g., for
+// spans measured with wall clocks across a clock adjustment, a warning is
+// logged and the sample is clamped to a zero duration.
+func newSample(start, end time.Time, failed bool) sample {
+	if end.Before(start) {
+		getLogger().Warn("sample ends before its start, duration clamped to zero",
+			slog.Time("start", start),
+			slog.Time("end", end))
+		end = start
+	}
+	return sample{start: start, end: end, failed: failed}
 }
 
+// getDurationNano returns the duration of s in nanoseconds, 0 if negative.
 func (s sample) getDurationNano() uint64 {
-	return uint64(s.end.Sub(s.start).Nanoseconds())
+	d := s.end.Sub(s.start)
+	if d < 0 {
+		return 0
+	}
+	return uint64(d.Nanoseconds())
+}
+
+// duration returns the duration of s, 0 if negative.
+func (s sample) duration() time.Duration {
+	return time.Duration(s.getDurationNano())
+}
+
+// scaled returns s with its duration multiplied by factor, keeping its end so
+// that recency is not affected (see SetDurationScale).
+func (s sample) scaled(factor float64) sample {
+	d := float64(s.getDurationNano()) * factor
+	if d > math.MaxInt64 {
+		d = math.MaxInt64
+	}
+	s.start = s.end.Add(-time.Duration(d))
+	return s
+}
+
+// wideSum is a 128-bit sum of nanoseconds. Unlike uint64, overflowing after
+// about 584 years of cumulated runtime, e.g., of many threads recording
+// samples for months, it cannot overflow in practice: means computed from it
+// remain exact, while the reported runtimes are clamped (see duration).
+type wideSum struct {
+	hi, lo uint64
+}
+
+// wideOf returns the sum containing v.
+func wideOf(v uint64) wideSum {
+	return wideSum{lo: v}
+}
+
+// add adds v to w.
+func (w *wideSum) add(v wideSum) {
+	var carry uint64
+	w.lo, carry = bits.Add64(w.lo, v.lo, 0)
+	w.hi, _ = bits.Add64(w.hi, v.hi, carry)
+}
+
+// div returns w / n, n must not be zero.
+func (w wideSum) div(n uint64) wideSum {
+	q := wideSum{hi: w.hi / n}
+	q.lo, _ = bits.Div64(w.hi%n, w.lo, n)
+	return q
+}
+
+// less returns whether w < v.
+func (w wideSum) less(v wideSum) bool {
+	return w.hi < v.hi || (w.hi == v.hi && w.lo < v.lo)
+}
+
+func (w wideSum) isZero() bool {
+	return w.hi == 0 && w.lo == 0
+}
+
+// float returns w as a float64, possibly rounded.
+func (w wideSum) float() float64 {
+	return float64(w.hi)*0x1p64 + float64(w.lo)
+}
+
+// ratio returns w / whole, 0 if whole is 0 (see ratio).
+func (w wideSum) ratio(whole wideSum) float64 {
+	if whole.isZero() {
+		return 0
+	}
+	return w.float() / whole.float()
+}
+
+// fitsDuration returns whether w can be represented as a [time.Duration].
+func (w wideSum) fitsDuration() bool {
+	return w.hi == 0 && w.lo <= math.MaxInt64
+}
+
+// duration returns w as a [time.Duration], clamped to the largest one.
+func (w wideSum) duration() time.Duration {
+	if !w.fitsDuration() {
+		return math.MaxInt64
+	}
+	return time.Duration(w.lo)
+}
+
+// nanos returns w, clamped to [math.MaxUint64].
+func (w wideSum) nanos() uint64 {
+	if w.hi > 0 {
+		return math.MaxUint64
+	}
+	return w.lo
 }
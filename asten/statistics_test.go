@@ -0,0 +1,340 @@
+package asten
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestRuntimeAccumulationNearMax(t *testing.T) {
+	restoreConfig(t)
+	SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// the sum of three samples exceeds math.MaxUint64 nanoseconds
+	const d = time.Duration(math.MaxInt64 - 1)
+
+	for _, tc := range []struct {
+		name string
+		opts []ProfileOption
+	}{
+		{"memoryless", nil},
+		{"memory full", []ProfileOption{WithMemory()}},
+		{"composite", []ProfileOption{WithComposite()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewProfile("huge", tc.opts...)
+			for i := 0; i < 10; i++ {
+				record(p, d)
+			}
+
+			snap := p.Snapshot()
+			if snap.NSamples != 10 {
+				t.Fatalf("%d samples, want 10", snap.NSamples)
+			}
+			if diff := math.Abs(float64(snap.MeanTime-d)) / float64(d); diff > 1e-9 {
+				t.Errorf("mean runtime %d, want %d", snap.MeanTime, d)
+			}
+			if snap.TotalTime != math.MaxInt64 || snap.EffectiveTime != math.MaxInt64 {
+				t.Errorf("runtimes %d and %d, want them clamped to %d",
+					snap.TotalTime, snap.EffectiveTime, time.Duration(math.MaxInt64))
+			}
+		})
+	}
+}
+
+func TestWideSum(t *testing.T) {
+	var w wideSum
+	for i := 0; i < 4; i++ {
+		w.add(wideOf(math.MaxUint64))
+	}
+	// 4 * (2^64 - 1) = 3 * 2^64 + (2^64 - 4)
+	if want := (wideSum{hi: 3, lo: math.MaxUint64 - 3}); w != want {
+		t.Errorf("sum %+v, want %+v", w, want)
+	}
+	if q := w.div(4); q != wideOf(math.MaxUint64) {
+		t.Errorf("quotient %+v, want %+v", q, wideOf(math.MaxUint64))
+	}
+	if w.nanos() != math.MaxUint64 || w.duration() != math.MaxInt64 {
+		t.Error("sum not clamped")
+	}
+}
+
+func TestNestedThreads(t *testing.T) {
+	for _, memory := range []bool{false, true} {
+		pb := NewProfileBuilder().AddComposition().WithNCores(4)
+		if memory {
+			pb.AddMemory()
+		}
+		p := pb.NewProfile("p")
+
+		// 8ms on 4 threads: 2ms
+		for i := 0; i < 8; i++ {
+			record(p, time.Millisecond, "a")
+		}
+		// single samples, not shortened by the threads: 2ms and 1ms
+		record(p, 2*time.Millisecond, "b", "x")
+		record(p, time.Millisecond, "b", "y")
+		p.Flush()
+
+		a, b := p.Profile("a").stats, p.Profile("b")
+		if a.effectiveTime.duration() != 2*time.Millisecond {
+			t.Errorf("memory=%t: child effective %v, want 2ms", memory, a.effectiveTime.duration())
+		}
+		if b.stats.effectiveTime.duration() != 3*time.Millisecond {
+			t.Errorf("memory=%t: nested composite effective %v, want 3ms",
+				memory, b.stats.effectiveTime.duration())
+		}
+		for name, sp := range b.subProfiles {
+			if sp.stats.effectiveTime != sp.stats.totalTime {
+				t.Errorf("memory=%t: %s effective %v, want its only sample %v", memory, name,
+					sp.stats.effectiveTime.duration(), sp.stats.totalTime.duration())
+			}
+		}
+		// composite profiles sum their sub-profiles, without dividing again
+		if p.stats.totalTime.duration() != 11*time.Millisecond || p.stats.effectiveTime.duration() != 5*time.Millisecond {
+			t.Errorf("memory=%t: total %v effective %v, want 11ms and 5ms", memory,
+				p.stats.totalTime.duration(), p.stats.effectiveTime.duration())
+		}
+		if p.stats.meanTime != float64(500*time.Microsecond) {
+			t.Errorf("memory=%t: mean %v, want 500µs", memory, time.Duration(p.stats.meanTime))
+		}
+	}
+}
+
+func TestThreadsMemorylessMatchesMemoryFull(t *testing.T) {
+	memoryless := NewProfileBuilder().WithNCores(3).NewProfile("memoryless")
+	memoryFull := NewProfileBuilder().WithNCores(3).AddMemory().NewProfile("memory full")
+	for i := 1; i <= 100; i++ {
+		d := time.Duration(i*i) * time.Microsecond
+		record(memoryless, d)
+		record(memoryFull, d)
+		memoryless.Flush()
+		memoryFull.Flush()
+
+		a, b := memoryless.stats, memoryFull.stats
+		if a.effectiveTime != b.effectiveTime || a.meanTime != b.meanTime {
+			t.Fatalf("after %d samples: memoryless effective %v mean %v, memory full %v %v", i,
+				a.effectiveTime.duration(), time.Duration(a.meanTime),
+				b.effectiveTime.duration(), time.Duration(b.meanTime))
+		}
+	}
+}
+
+func TestMeanAgainstReference(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+
+	const n = 100000
+	rng := rand.New(rand.NewSource(1))
+	durations := make([]time.Duration, n)
+	for i := range durations {
+		// long samples, whose sums lose precision as float64
+		durations[i] = time.Hour + time.Duration(rng.Int63n(int64(time.Hour)))
+	}
+
+	// exact mean and two-pass standard deviation
+	sum := new(big.Int)
+	for _, d := range durations {
+		sum.Add(sum, big.NewInt(int64(d)))
+	}
+	mean, _ := new(big.Float).Quo(new(big.Float).SetInt(sum), big.NewFloat(n)).Float64()
+	var m2 float64
+	for _, d := range durations {
+		m2 += (float64(d) - mean) * (float64(d) - mean)
+	}
+	stdDev := math.Sqrt(m2 / (n - 1))
+
+	memoryless := NewProfile("memoryless")
+	memoryFull := NewProfile("memory full", WithMemory())
+	composite := NewProfile("composite", WithComposite())
+	for i, d := range durations {
+		record(memoryless, d)
+		record(memoryFull, d)
+		record(composite, d, []string{"a", "b", "c"}[i%3])
+	}
+
+	for _, p := range []*ProfileSt{memoryless, memoryFull, composite} {
+		p.Snapshot()
+		if got := p.stats.meanTime; math.Abs(got-mean) > 1 {
+			t.Errorf("%s: mean %f ns, want %f ns", p.name, got, mean)
+		}
+		if got := p.stats.stdDev(); math.Abs(got-stdDev) > stdDev*1e-9 {
+			t.Errorf("%s: standard deviation %f ns, want %f ns", p.name, got, stdDev)
+		}
+	}
+}
+
+func TestMergeMomentsAgainstReference(t *testing.T) {
+	restoreConfig(t)
+	SetSuppressCompositeWarnings(true)
+
+	// sub-profiles of different sizes and distributions, one of them nested
+	// and one without samples
+	rng := rand.New(rand.NewSource(1))
+	parts := []struct {
+		conds []string
+		n     int
+		base  time.Duration
+	}{
+		{[]string{"single"}, 1, time.Second},
+		{[]string{"fast"}, 1000, time.Millisecond},
+		{[]string{"slow", "db"}, 10, time.Minute},
+		{[]string{"slow", "cache"}, 100, time.Microsecond},
+	}
+	p := NewProfile("p", WithComposite())
+	p.Profile("empty")
+	var durations []float64
+	for _, part := range parts {
+		for i := 0; i < part.n; i++ {
+			d := part.base + time.Duration(rng.Int63n(int64(part.base)))
+			record(p, d, part.conds...)
+			durations = append(durations, float64(d))
+		}
+	}
+
+	// two-pass reference
+	var mean float64
+	for _, d := range durations {
+		mean += d
+	}
+	n := float64(len(durations))
+	mean /= n
+	var m2 float64
+	for _, d := range durations {
+		m2 += (d - mean) * (d - mean)
+	}
+	stdDev := math.Sqrt(m2 / (n - 1))
+	stdErr := stdDev / math.Sqrt(n)
+
+	p.Snapshot()
+	if got := p.stats.stdDev(); math.Abs(got-stdDev) > stdDev*1e-9 {
+		t.Errorf("standard deviation %f ns, want %f ns", got, stdDev)
+	}
+	if got := p.stats.stdErr(); math.Abs(got-stdErr) > stdErr*1e-9 {
+		t.Errorf("standard error %f ns, want %f ns", got, stdErr)
+	}
+	if got := p.StdDev(); got != time.Duration(p.stats.stdDev()) {
+		t.Errorf("StdDev %v, want %v", got, time.Duration(p.stats.stdDev()))
+	}
+}
+
+func TestFewSamplesManyThreads(t *testing.T) {
+	restoreConfig(t)
+	SetAllowOvercommit(true)
+
+	for _, memory := range []bool{false, true} {
+		opts := []ProfileOption{WithThreads(16)}
+		if memory {
+			opts = append(opts, WithMemory())
+		}
+		p := NewProfile("p", opts...)
+
+		if snap := p.Snapshot(); snap.EffectiveTime != 0 || snap.MeanTime != 0 {
+			t.Errorf("memory=%t: no samples, effective %v mean %v, want 0",
+				memory, snap.EffectiveTime, snap.MeanTime)
+		}
+
+		// a single sample cannot finish faster than itself
+		record(p, 10*time.Millisecond)
+		if snap := p.Snapshot(); snap.EffectiveTime != 10*time.Millisecond {
+			t.Errorf("memory=%t: one sample, effective %v, want 10ms", memory, snap.EffectiveTime)
+		}
+
+		// 16ms on 16 threads, bounded by the longest sample
+		record(p, 3*time.Millisecond)
+		record(p, 3*time.Millisecond)
+		if snap := p.Snapshot(); snap.EffectiveTime != 10*time.Millisecond || snap.MeanTime != 10*time.Millisecond/3 {
+			t.Errorf("memory=%t: three samples, effective %v mean %v, want 10ms and %v",
+				memory, snap.EffectiveTime, snap.MeanTime, 10*time.Millisecond/3)
+		}
+
+		// once enough samples ran, the runtime divided by the threads prevails
+		for i := 0; i < 77; i++ {
+			record(p, 2*time.Millisecond)
+		}
+		if snap := p.Snapshot(); snap.EffectiveTime != 10625*time.Microsecond || snap.TotalTime != 170*time.Millisecond {
+			t.Errorf("memory=%t: 80 samples, total %v effective %v, want 170ms and 10.625ms",
+				memory, snap.TotalTime, snap.EffectiveTime)
+		}
+	}
+}
+
+// sink keeps the allocations of the tests from being optimized away.
+var sink []byte
+
+func TestAggregateReplacesSamples(t *testing.T) {
+	restoreConfig(t)
+
+	p := NewProfile("p", WithApproxPercentiles(0.5), WithAllocTracking())
+	for i := 0; i < 3; i++ {
+		p.StartTimer().StopErr(errors.New("failed"))
+	}
+	tm := p.StartTimer()
+	sink = make([]byte, 1<<20)
+	tm.StopWithAlloc()
+	if p.ErrorRate() == 0 || p.Percentile(0.5) == 0 || p.MeanAlloc() == 0 {
+		t.Fatal("statistics of the samples not recorded")
+	}
+
+	p.SetAggregate(time.Millisecond, time.Millisecond, 1)
+	if r := p.ErrorRate(); r != 0 {
+		t.Errorf("error rate %v, want 0", r)
+	}
+	if q := p.Percentile(0.5); q != 0 {
+		t.Errorf("median %v, want 0", q)
+	}
+	if a := p.MeanAlloc(); a != 0 {
+		t.Errorf("mean allocation %d, want 0", a)
+	}
+	if m := p.Snapshot().MeanTime; m != time.Millisecond {
+		t.Errorf("mean time %v, want %v", m, time.Millisecond)
+	}
+}
+
+func TestRollup(t *testing.T) {
+	restoreConfig(t)
+	SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// stages running in parallel, see RollupFunc
+	slowest := func(children []ProfileSnapshot) ProfileSnapshot {
+		var agg ProfileSnapshot
+		for _, c := range children {
+			agg.TotalTime += c.TotalTime
+			if c.EffectiveTime > agg.EffectiveTime {
+				agg.EffectiveTime = c.EffectiveTime
+			}
+			if c.NSamples > agg.NSamples {
+				agg.NSamples = c.NSamples
+			}
+		}
+		return agg
+	}
+	p := NewProfile("p", WithComposite(), WithRollup(slowest))
+	record(p, time.Millisecond, "fetch")
+	record(p, 3*time.Millisecond, "render")
+
+	snap := p.Snapshot()
+	if snap.TotalTime != 4*time.Millisecond || snap.EffectiveTime != 3*time.Millisecond || snap.NSamples != 1 {
+		t.Errorf("total %v, effective %v, NSamples = %d, want %v, %v and 1",
+			snap.TotalTime, snap.EffectiveTime, snap.NSamples, 4*time.Millisecond, 3*time.Millisecond)
+	}
+	if snap.MeanTime != 3*time.Millisecond {
+		t.Errorf("mean %v, want %v", snap.MeanTime, 3*time.Millisecond)
+	}
+
+	// invalid aggregates fall back to summation
+	q := NewProfile("q", WithComposite(), WithRollup(func([]ProfileSnapshot) ProfileSnapshot {
+		return ProfileSnapshot{TotalTime: -1}
+	}))
+	record(q, time.Millisecond, "fetch")
+	record(q, 3*time.Millisecond, "render")
+	if snap := q.Snapshot(); snap.EffectiveTime != 4*time.Millisecond || snap.NSamples != 2 {
+		t.Errorf("effective %v, NSamples = %d, want %v and 2", snap.EffectiveTime, snap.NSamples, 4*time.Millisecond)
+	}
+}
@@ -0,0 +1,58 @@
+package asten
+
+import (
+	"strings"
+	"testing"
+)
+
+// ProfileB returns a new profile named name, not belonging to any group, to be
+// used within benchmark b.
+// When b completes, the mean runtime of the profile and of each of its
+// non-composite descendants is reported as a custom benchmark metric (see
+// [testing.B.ReportMetric]) whose unit is "ns/" followed by the sanitized names
+// (see [SetLabelSanitizer]) of the profile and its ancestors, e.g.:
+//
+//	ns/parse  ns/parse/json  ns/parse/xml
+func ProfileB(b *testing.B, name string) *ProfileSt {
+	p := NewProfileBuilder().NewProfile(name)
+
+	b.Cleanup(func() {
+		p.recursiveLock()
+		defer p.recursiveUnlock()
+		p.update()
+
+		b.ReportMetric(float64(p.stats.meanTime), metricUnit(p))
+		if p.composite {
+			p.forEachLeaf(func(leaf *ProfileSt) {
+				b.ReportMetric(float64(leaf.stats.meanTime), metricUnit(leaf))
+			})
+		}
+	})
+
+	return p
+}
+
+// metricUnit returns the unit used to report the mean runtime of p as a
+// benchmark metric.
+func metricUnit(p *ProfileSt) string {
+	names := p.path()
+	for i := range names {
+		names[i] = sanitizeLabel(names[i])
+	}
+	// units cannot contain white spaces, even with a custom sanitizer
+	return "ns/" + strings.Join(strings.Fields(strings.Join(names, "/")), "_")
+}
+
+// AttachToTest makes the tables of group g be written to the log of t (see
+// [GroupSt.Fprint]) when t and all its subtests complete, only if t failed.
+func AttachToTest(t testing.TB, g *GroupSt) {
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+
+		var b strings.Builder
+		g.Fprint(&b)
+		t.Log(b.String())
+	})
+}
@@ -1,27 +1,156 @@
 package asten
 
-import "time"
+import (
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// # Clock
+//
+// Clock provides the current time to timers. A custom Clock can be set using
+// [SetClock], e.g., to obtain deterministic durations in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the default Clock, it relies on [time.Now]
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SetClock sets the clock used by timers to read the current time.
+// If c is nil the default clock, based on [time.Now], is restored.
+func SetClock(c Clock) {
+	if c == nil {
+		c = systemClock{}
+	}
+	updateConfig(func(cfg *Config) {
+		cfg.clock = c
+	})
+}
 
 // # Timer
 //
 // Represents a running timer.
 // Its zero value has no meaning. A Timer should always be instantiated by
 // calling either [GroupSt.StartTimer] or [ProfileSt.StartTimer].
+// A Timer is not safe for concurrent use, but it can be stopped by a goroutine
+// other than the one that started it, as long as the Timer is passed between
+// them with proper synchronization, e.g., through a channel. [Timer.Handoff]
+// makes such transfers of ownership explicit.
 type Timer struct {
 	profile *ProfileSt
 	conds   []string
 	start   time.Time
 	end     time.Time
+	failed  bool
+
+	tracksAlloc bool
+	startAlloc  uint64
+	alloc       uint64
+
+	tracksGC   bool
+	startGC    uint64
+	gcAffected bool // a GC cycle completed while running, see WithGCAttribution
+
+	tracksCPU bool // not carried by handoffs, see WithCPUTime
+	startCPU  time.Duration
+	cpu       time.Duration
+
+	handedOff bool          // see Handoff
+	watch     *watchedTimer // see WithTimerTimeout
+}
+
+// # TimerToken
+//
+// Represents a measurement handed off by a [Timer] (see [Timer.Handoff]), to be
+// resumed using [ResumeTimer]. A TimerToken is a plain value that can be
+// copied and passed between goroutines or pipeline stages of the same
+// process. It must be resumed at most once.
+type TimerToken struct {
+	profile     *ProfileSt
+	start       time.Time
+	tracksAlloc bool
+	startAlloc  uint64
+	tracksGC    bool
+	startGC     uint64
+	watch       *watchedTimer
+}
+
+// Handoff relinquishes timer t and returns a token carrying its measurement,
+// which can be resumed by another goroutine, e.g., a worker processing a job
+// enqueued while t was running:
+//
+//	tok := Profile("job").StartTimer().Handoff()
+//	queue <- job{token: tok}
+//	// on the worker goroutine
+//	t := ResumeTimer(j.token)
+//	// ...
+//	t.Stop()
+//
+// The start time of the measurement is preserved, as well as its timeout if
+// any (see [ProfileBuilder.WithTimerTimeout]). t must not be used
+// afterwards: stopping it logs an error and records nothing.
+func (t *Timer) Handoff() TimerToken {
+	t.handedOff = true
+	return TimerToken{
+		profile:     t.profile,
+		start:       t.start,
+		tracksAlloc: t.tracksAlloc,
+		startAlloc:  t.startAlloc,
+		tracksGC:    t.tracksGC,
+		startGC:     t.startGC,
+		watch:       t.watch,
+	}
+}
+
+// ResumeTimer returns a running timer continuing the measurement handed off as
+// tok (see [Timer.Handoff]). It returns nil, logging an error, if tok is the
+// zero TimerToken.
+func ResumeTimer(tok TimerToken) *Timer {
+	if tok.profile == nil {
+		getLogger().Error("invalid timer token, use Timer.Handoff to obtain one")
+		return nil
+	}
+
+	return &Timer{
+		profile:     tok.profile,
+		start:       tok.start,
+		tracksAlloc: tok.tracksAlloc,
+		startAlloc:  tok.startAlloc,
+		tracksGC:    tok.tracksGC,
+		startGC:     tok.startGC,
+		watch:       tok.watch,
+	}
+}
+
+// handedOffStop returns whether t has been handed off, logging an error if so.
+func (t *Timer) handedOffStop() bool {
+	if t.handedOff {
+		getLogger().Error("attempt to stop a handed off timer, use ResumeTimer",
+			slog.String("profile", t.profile.getFullName()))
+	}
+	return t.handedOff
 }
 
 // Stop is equivalent to calling:
 //
-//	t.StopAs([]string{default_condition_name})
+//	t.StopAs(default_condition_name)
 //
-// (see [SetDefaultConditionName]).
+// where default_condition_name is the default condition of the profile that
+// started the timer (see [ProfileBuilder.WithDefaultCondition] and
+// [SetDefaultConditionName]).
 func (t *Timer) Stop() {
-	t.end = time.Now()
-	t.conds = []string{default_condition_name}
+	t.end = conf().clock.Now()
+	if t.handedOffStop() {
+		return
+	}
+	timerWatchdog.unwatch(t)
+	t.stopCounters()
+	t.conds = []string{t.profile.defaultConditionName()}
 	t.profile.registerTimer(t)
 }
 
@@ -49,7 +178,167 @@ func (t *Timer) Stop() {
 //
 // If bar is composite (see [SetDefaultConditionName]).
 func (t *Timer) StopAs(conds ...string) {
-	t.end = time.Now()
+	t.end = conf().clock.Now()
+	if t.handedOffStop() {
+		return
+	}
+	timerWatchdog.unwatch(t)
+	t.stopCounters()
 	t.conds = conds
 	t.profile.registerTimer(t)
 }
+
+// StopMulti stops the timer and registers the same sample once for each of the
+// condition paths targets, as [Timer.StopAs] would, e.g.:
+//
+//	t := Profile("request").StartTimer()
+//	// serialize and send the response
+//	t.StopMulti([]string{"serialization"}, []string{"total"})
+//
+// An empty path registers the sample as [Timer.Stop] would, as does calling
+// StopMulti without targets.
+// The measured time is counted once per target by design: the runtimes of the
+// profile that started the timer, and of any common ancestor of the targets,
+// are inflated accordingly.
+func (t *Timer) StopMulti(targets ...[]string) {
+	t.end = conf().clock.Now()
+	if t.handedOffStop() {
+		return
+	}
+	timerWatchdog.unwatch(t)
+	t.stopCounters()
+
+	if len(targets) == 0 {
+		targets = [][]string{nil}
+	}
+	for _, conds := range targets {
+		if len(conds) == 0 {
+			conds = []string{t.profile.defaultConditionName()}
+		}
+		t.conds = conds
+		t.profile.registerTimer(t)
+	}
+}
+
+// StopWeighted stops the timer and splits the measured duration among the
+// sibling conditions in weights, proportionally to their weight, e.g., for a
+// request measured by a single timer across its stages:
+//
+//	t.StopWeighted(map[string]float64{"parse": 1, "query": 3})
+//
+// registers a sample lasting a quarter of the measured duration under the
+// condition "parse" and one lasting three quarters under "query", as
+// [Timer.StopAs] would. Allocations measured by the timer are split likewise.
+// Each condition counts the span as one sample: the profile that started the
+// timer thus counts it once per condition, which lowers its mean runtime.
+// Conditions with non-positive weights are ignored, an error is logged and
+// nothing is recorded if no weight is positive.
+func (t *Timer) StopWeighted(weights map[string]float64) {
+	t.end = conf().clock.Now()
+	if t.handedOffStop() {
+		return
+	}
+	timerWatchdog.unwatch(t)
+	t.stopCounters()
+
+	conds := sortedKeys(weights)
+	var sum float64
+	for _, cond := range conds {
+		if w := weights[cond]; w > 0 {
+			sum += w
+		}
+	}
+	if sum == 0 {
+		getLogger().Error("no positive weight, sample discarded",
+			slog.String("profile", t.profile.getFullName()))
+		return
+	}
+
+	// the shares are the differences between the cumulated fractions of the
+	// measures, summed in the same order as sum, so that they add up exactly
+	// to the measures despite rounding
+	d := t.end.Sub(t.start)
+	var cumulated float64
+	var prevD, prevCPU time.Duration
+	var prevAlloc uint64
+	for _, cond := range conds {
+		w := weights[cond]
+		if w <= 0 {
+			continue
+		}
+		cumulated += w
+		f := cumulated / sum
+		curD := time.Duration(float64(d) * f)
+		curAlloc := uint64(float64(t.alloc) * f)
+		curCPU := time.Duration(float64(t.cpu) * f)
+
+		share := *t
+		share.end = t.start.Add(curD - prevD)
+		share.alloc = curAlloc - prevAlloc
+		share.cpu = curCPU - prevCPU
+		share.conds = []string{cond}
+		t.profile.registerTimer(&share)
+
+		prevD, prevAlloc, prevCPU = curD, curAlloc, curCPU
+	}
+}
+
+// StopErr is equivalent to [Timer.StopAs] but, if err is not nil, the sample is
+// also counted as a failure (see [ProfileSt.ErrorRate]).
+// If no condition is specified it is equivalent to [Timer.Stop].
+func (t *Timer) StopErr(err error, conds ...string) {
+	t.failed = err != nil
+	if len(conds) == 0 {
+		t.Stop()
+		return
+	}
+	t.StopAs(conds...)
+}
+
+// StopWithAlloc is equivalent to [Timer.StopAs] but the number of bytes
+// allocated on the heap while the timer was running is recorded as well (see
+// [ProfileSt.MeanAlloc]). If no condition is specified it is equivalent to
+// [Timer.Stop].
+//
+// The timer must have been started by a profile tracking allocations (see
+// [ProfileBuilder.WithAllocTracking]). Since the allocation counter is
+// program-wide, allocations performed concurrently by other goroutines are
+// attributed to the sample as well: measures are only reliable when the timed
+// code runs alone.
+func (t *Timer) StopWithAlloc(conds ...string) {
+	if t.tracksAlloc {
+		t.alloc = readAllocBytes() - t.startAlloc
+	} else {
+		getLogger().Warn("timer does not track allocations, see WithAllocTracking",
+			slog.String("profile", t.profile.getFullName()))
+	}
+
+	if len(conds) == 0 {
+		t.Stop()
+		return
+	}
+	t.StopAs(conds...)
+}
+
+// stopCounters records whether a GC cycle completed while t was running, if t
+// attributes GCs (see [ProfileBuilder.WithGCAttribution]), and the CPU time it
+// consumed, if t measures it (see [ProfileBuilder.WithCPUTime]).
+func (t *Timer) stopCounters() {
+	if t.tracksGC {
+		t.gcAffected = readGCCycles() != t.startGC
+	}
+	if t.tracksCPU {
+		if end, ok := readThreadCPUTime(); ok && end > t.startCPU {
+			t.cpu = end - t.startCPU
+		}
+	}
+}
+
+// sample returns the sample measured by t.
+func (t *Timer) sample() sample {
+	s := newSample(t.start, t.end, t.failed)
+	s.alloc = t.alloc
+	s.gcAffected = t.gcAffected
+	s.cpu = uint64(t.cpu)
+	return s
+}
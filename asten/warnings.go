@@ -0,0 +1,60 @@
+package asten
+
+import "fmt"
+
+// Warnings returns a description of the suspicious shapes found among the
+// profiles of group g, which often reveal instrumentation mistakes:
+//   - composite profiles whose only sub-profile having samples is the one of
+//     the default condition, typically because timers are stopped using
+//     [Timer.Stop] after the profile has been made composite;
+//   - composite profiles without sub-profiles;
+//   - non-composite profiles without samples.
+//
+// The same warnings are displayed below the table of g by the Print functions.
+func (g *GroupSt) Warnings() []string {
+	g.recursiveLock()
+	defer g.recursiveUnlock()
+	g.update()
+
+	return g.warnings()
+}
+
+// warnings returns the warnings of the (already updated) group g.
+func (g *GroupSt) warnings() []string {
+	var ws []string
+	for _, pname := range sortedKeys(g.profiles) {
+		ws = g.profiles[pname].warnings(ws)
+	}
+	return ws
+}
+
+// warnings appends to ws the warnings of the (already updated) profile p and
+// of its descendants (see [GroupSt.Warnings]).
+func (p *ProfileSt) warnings(ws []string) []string {
+	if !p.composite {
+		if p.stats.count() == 0 {
+			ws = append(ws, fmt.Sprintf("%s: no samples recorded", p.getFullName()))
+		}
+		return ws
+	}
+
+	if len(p.subProfiles) == 0 {
+		return append(ws, fmt.Sprintf("%s: composite profile without sub-profiles", p.getFullName()))
+	}
+
+	var populated []string
+	for _, spName := range sortedKeys(p.subProfiles) {
+		if p.subProfiles[spName].stats.count() > 0 {
+			populated = append(populated, spName)
+		}
+	}
+	if defaultCond := p.defaultConditionName(); len(populated) == 1 && populated[0] == defaultCond {
+		ws = append(ws, fmt.Sprintf("%s: only the default condition %q has samples, timers may be stopped using Stop instead of StopAs",
+			p.getFullName(), defaultCond))
+	}
+
+	for _, spName := range sortedKeys(p.subProfiles) {
+		ws = p.subProfiles[spName].warnings(ws)
+	}
+	return ws
+}
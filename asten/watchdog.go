@@ -0,0 +1,147 @@
+package asten
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// watchdog keeps track of the running timers of profiles having a timer
+// timeout (see [ProfileBuilder.WithTimerTimeout]) and periodically warns about
+// the ones running for longer than their timeout.
+// A single background goroutine, running while timers are watched, scans all
+// the watched timers.
+type watchdog struct {
+	sync.Mutex
+	timers map[*watchedTimer]struct{}
+	period time.Duration
+	ticker *time.Ticker  // nil if no timer is watched
+	done   chan struct{} // closed to stop the goroutine scanning on ticker
+}
+
+// watchedTimer contains the information needed to report a leaked timer. It
+// is shared by timers continuing the same measurement (see Timer.Handoff).
+type watchedTimer struct {
+	profile  *ProfileSt
+	deadline time.Time
+	pcs      []uintptr // call stack of the StartTimer call, see stack
+}
+
+// minWatchdogPeriod is the lower bound of the scan period of the watchdog
+const minWatchdogPeriod = 10 * time.Millisecond
+
+// maxWatchedFrames is the maximum number of frames of the call stacks of the
+// watched timers
+const maxWatchedFrames = 32
+
+var timerWatchdog = watchdog{timers: make(map[*watchedTimer]struct{})}
+
+// watch starts watching t, which is expected to be stopped within timeout.
+func (w *watchdog) watch(t *Timer, timeout time.Duration) {
+	// the program counters are cheap to capture, they are only symbolized
+	// when reporting the timer, which is rare
+	pcs := make([]uintptr, maxWatchedFrames)
+	// skip runtime.Callers, watch and StartTimer
+	pcs = pcs[:runtime.Callers(3, pcs)]
+
+	wt := &watchedTimer{
+		profile:  t.profile,
+		deadline: time.Now().Add(timeout),
+		pcs:      pcs,
+	}
+
+	// scan at least twice per timeout
+	period := timeout / 2
+	if period < minWatchdogPeriod {
+		period = minWatchdogPeriod
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	t.watch = wt
+	w.timers[wt] = struct{}{}
+
+	switch {
+	case w.ticker == nil:
+		w.period = period
+		w.ticker = time.NewTicker(period)
+		w.done = make(chan struct{})
+		go w.run(w.ticker, w.done)
+	case period < w.period:
+		w.period = period
+		w.ticker.Reset(period)
+	}
+}
+
+// unwatch stops watching t, if watched.
+func (w *watchdog) unwatch(t *Timer) {
+	if t.watch == nil {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	delete(w.timers, t.watch)
+	w.stopIfIdle()
+}
+
+// stopIfIdle stops the goroutine scanning the timers if none is watched. The
+// watchdog must be locked.
+func (w *watchdog) stopIfIdle() {
+	if len(w.timers) > 0 || w.ticker == nil {
+		return
+	}
+	w.ticker.Stop()
+	close(w.done)
+	w.ticker = nil
+	w.done = nil
+}
+
+func (w *watchdog) run(ticker *time.Ticker, done <-chan struct{}) {
+	for {
+		select {
+		case now := <-ticker.C:
+			w.scan(now)
+		case <-done:
+			return
+		}
+	}
+}
+
+// scan warns about the timers whose deadline precedes now, each of them is
+// reported only once.
+func (w *watchdog) scan(now time.Time) {
+	w.Lock()
+	defer w.Unlock()
+
+	for wt := range w.timers {
+		if now.Before(wt.deadline) {
+			continue
+		}
+		getLogger().Warn("timer running for longer than its timeout, it may never be stopped",
+			slog.String("profile", wt.profile.getFullName()),
+			slog.String("stack", wt.stack()))
+		delete(w.timers, wt)
+	}
+	w.stopIfIdle()
+}
+
+// stack returns the call stack of the StartTimer call of wt, formatted as by
+// [runtime/debug.Stack].
+func (wt *watchedTimer) stack() string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(wt.pcs)
+	for {
+		f, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", f.Function, f.File, f.Line)
+		if !more {
+			return b.String()
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package asten
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	sync.Mutex
+	b bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.b.String()
+}
+
+// watchdogIdle returns whether the watchdog goroutine is stopped.
+func watchdogIdle() bool {
+	timerWatchdog.Lock()
+	defer timerWatchdog.Unlock()
+	return timerWatchdog.ticker == nil
+}
+
+func TestWatchdogReportsCaller(t *testing.T) {
+	restoreConfig(t)
+	var logs syncBuffer
+	SetLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	p := NewProfile("p", WithTimerTimeout(time.Millisecond))
+	p.StartTimer()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "timeout") && time.Now().Before(deadline) {
+		time.Sleep(minWatchdogPeriod)
+	}
+
+	out := logs.String()
+	if !strings.Contains(out, "TestWatchdogReportsCaller") {
+		t.Errorf("report lacks the caller of StartTimer:\n%s", out)
+	}
+	if strings.Contains(out, "asten.(*watchdog).watch") {
+		t.Errorf("report contains the frames of the watchdog:\n%s", out)
+	}
+	if !watchdogIdle() {
+		t.Error("watchdog running after reporting its only timer")
+	}
+}
+
+func TestWatchdogStopsWhenIdle(t *testing.T) {
+	p := NewProfile("p", WithTimerTimeout(time.Hour))
+
+	t1, t2 := p.StartTimer(), p.StartTimer()
+	if watchdogIdle() {
+		t.Fatal("watchdog not running while timers are watched")
+	}
+	t1.Stop()
+	if watchdogIdle() {
+		t.Error("watchdog stopped while a timer is watched")
+	}
+	t2.Stop()
+	if !watchdogIdle() {
+		t.Error("watchdog running without watched timers")
+	}
+
+	// restarted on demand
+	p.StartTimer().Stop()
+	if !watchdogIdle() {
+		t.Error("watchdog running without watched timers")
+	}
+}